@@ -0,0 +1,184 @@
+package checkpoint
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// Name of the manifest file that is stored next to the dumped files of each table
+const FILENAME string = "import_checkpoint.jsonl"
+
+type Status string
+
+const (
+	DONE   Status = "done"
+	FAILED Status = "failed"
+	// Some batches of the series were committed, see `Manifest.Batch`
+	BATCH Status = "batch"
+)
+
+// Identifies a single series inside a dumped table.
+// For KDVH `Series` is the element code, for Kvalobs it is the label filename.
+type Key struct {
+	Table   string `json:"table"`
+	Station int32  `json:"station"`
+	Series  string `json:"series"`
+}
+
+// Line of the manifest file
+type Entry struct {
+	Key
+	Status Status     `json:"status"`
+	Rows   int64      `json:"rows"`
+	Batch  int        `json:"batch,omitempty"` // Number of committed batches
+	Until  *time.Time `json:"until,omitempty"` // Obstime of the last committed row
+	Error  string     `json:"error,omitempty"`
+	Time   time.Time  `json:"time"`
+}
+
+// Manifest keeps track of the series that were already processed.
+// Entries are appended to the file as soon as a series is completed,
+// so that the state survives crashes. If the same key appears multiple times,
+// the last entry wins.
+type Manifest struct {
+	mutex   sync.Mutex
+	file    *os.File
+	entries map[Key]Entry
+}
+
+// Opens the manifest stored at `path`.
+// If `resume` is false, any previous manifest is truncated.
+func Open(path string, resume bool) (*Manifest, error) {
+	manifest := &Manifest{entries: make(map[Key]Entry)}
+
+	var missingNewline bool
+	flags := os.O_CREATE | os.O_WRONLY | os.O_TRUNC
+	if resume {
+		var err error
+		if missingNewline, err = manifest.load(path); err != nil {
+			return nil, err
+		}
+		flags = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	}
+
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, err
+	}
+	manifest.file = file
+
+	// Terminate the incomplete line, so new entries are not appended to it
+	if missingNewline {
+		if _, err := file.Write([]byte{'\n'}); err != nil {
+			file.Close()
+			return nil, err
+		}
+	}
+
+	return manifest, nil
+}
+
+// Loads previous entries. Returns `true` if the file does not end with a newline
+func (m *Manifest) load(path string) (bool, error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	reader := bufio.NewReader(file)
+	for line := 1; ; line++ {
+		bytes, err := reader.ReadBytes('\n')
+		if len(bytes) > 0 {
+			var entry Entry
+			if jsonErr := json.Unmarshal(bytes, &entry); jsonErr != nil {
+				// The last line could be incomplete if the program crashed while writing it,
+				// in which case the series will simply be imported again
+				slog.Warn(fmt.Sprintf("Skipping line %d of checkpoint manifest %q: %s", line, path, jsonErr))
+			} else {
+				m.entries[entry.Key] = entry
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return len(bytes) > 0, nil
+		} else if err != nil {
+			return false, err
+		}
+	}
+}
+
+// Returns `true` if the series was successfully imported in a previous run
+func (m *Manifest) IsDone(table string, station int32, series string) bool {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry, ok := m.entries[Key{table, station, series}]
+	return ok && entry.Status == DONE
+}
+
+// Marks the series as completed
+func (m *Manifest) Done(table string, station int32, series string, rows int64) error {
+	return m.write(Entry{Key: Key{table, station, series}, Status: DONE, Rows: rows})
+}
+
+// Marks the series as failed, so it is retried on the next resumed run.
+// The committed batches are kept, so the retry continues after them.
+func (m *Manifest) Failed(table string, station int32, series string, err error) error {
+	entry := Entry{Key: Key{table, station, series}, Status: FAILED}
+	if err != nil {
+		entry.Error = err.Error()
+	}
+
+	progress := m.Progress(table, station, series)
+	entry.Rows, entry.Batch, entry.Until = progress.Rows, progress.Batch, progress.Until
+	return m.write(entry)
+}
+
+// Records a batch of the series committed to LARD. `rows` is the total number
+// of rows committed so far, and `until` the obstime of the last one.
+func (m *Manifest) Batch(table string, station int32, series string, batch int, until time.Time, rows int64) error {
+	return m.write(Entry{Key: Key{table, station, series}, Status: BATCH, Rows: rows, Batch: batch, Until: &until})
+}
+
+// Returns the batches of the series committed by previous runs that did not complete it.
+// The returned entry is empty if there are none.
+func (m *Manifest) Progress(table string, station int32, series string) Entry {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	entry, ok := m.entries[Key{table, station, series}]
+	if !ok || entry.Status == DONE || entry.Until == nil {
+		return Entry{}
+	}
+	return entry
+}
+
+func (m *Manifest) write(entry Entry) error {
+	entry.Time = time.Now().UTC()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.entries[entry.Key] = entry
+	_, err = m.file.Write(append(line, '\n'))
+	return err
+}
+
+func (m *Manifest) Close() error {
+	return m.file.Close()
+}
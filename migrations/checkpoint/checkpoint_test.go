@@ -0,0 +1,110 @@
+package checkpoint
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FILENAME)
+
+	manifest, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	manifest.Done("T_MDATA", 18700, "TA", 100)
+	manifest.Failed("T_MDATA", 18700, "TAN", errors.New("No metadata"))
+	manifest.Failed("T_MDATA", 18700, "TAX", errors.New("No metadata"))
+	manifest.Done("T_MDATA", 18700, "TAX", 50)
+	manifest.Close()
+
+	// Simulate a crash in the middle of a write
+	file, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	file.WriteString(`{"table":"T_MDATA","station":18700,"ser`)
+	file.Close()
+
+	manifest, err = Open(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manifest.Close()
+
+	type testCase struct {
+		series   string
+		expected bool
+	}
+
+	cases := []testCase{
+		{"TA", true},
+		{"TAN", false},
+		{"TAX", true},
+		{"TAM", false},
+	}
+
+	for _, c := range cases {
+		if result := manifest.IsDone("T_MDATA", 18700, c.series); result != c.expected {
+			t.Errorf("%s: got %v, wanted %v", c.series, result, c.expected)
+		}
+	}
+
+	// New entries should not be appended to the truncated line
+	manifest.Done("T_MDATA", 18700, "TAN", 10)
+	reloaded := &Manifest{entries: make(map[Key]Entry)}
+	if _, err := reloaded.load(path); err != nil {
+		t.Fatal(err)
+	}
+	if !reloaded.IsDone("T_MDATA", 18700, "TAN") {
+		t.Error("TAN: got false, wanted true")
+	}
+
+	// A fresh run should discard previous entries
+	fresh, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fresh.Close()
+	if fresh.IsDone("T_MDATA", 18700, "TA") {
+		t.Error("TA: got true after truncation, wanted false")
+	}
+}
+
+func TestBatchProgress(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FILENAME)
+
+	manifest, err := Open(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	until := time.Date(2001, 7, 1, 0, 0, 0, 0, time.UTC)
+	manifest.Batch("T_MDATA", 18700, "TA", 1, until.Add(-time.Hour), 100)
+	manifest.Batch("T_MDATA", 18700, "TA", 2, until, 200)
+	manifest.Failed("T_MDATA", 18700, "TA", errors.New("connection reset"))
+	manifest.Batch("T_MDATA", 18700, "TAN", 1, until, 100)
+	manifest.Done("T_MDATA", 18700, "TAN", 150)
+	manifest.Close()
+
+	manifest, err = Open(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer manifest.Close()
+
+	// A failed series resumes after the last committed batch
+	progress := manifest.Progress("T_MDATA", 18700, "TA")
+	if progress.Batch != 2 || progress.Rows != 200 || progress.Until == nil || !progress.Until.Equal(until) {
+		t.Errorf("TA: got %+v, wanted batch 2 with 200 rows until %v", progress, until)
+	}
+	if manifest.IsDone("T_MDATA", 18700, "TA") {
+		t.Error("TA: got done, wanted false")
+	}
+
+	// Completed and missing series have no progress
+	for _, series := range []string{"TAN", "TAX"} {
+		if progress := manifest.Progress("T_MDATA", 18700, series); progress.Until != nil {
+			t.Errorf("%s: got %+v, wanted empty progress", series, progress)
+		}
+	}
+}
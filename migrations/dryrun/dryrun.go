@@ -0,0 +1,171 @@
+package dryrun
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocarina/gocsv"
+	"github.com/jackc/pgx/v5"
+)
+
+// What would happen to the LARD timeseries of a series
+type Action string
+
+const (
+	REUSE  Action = "reuse"
+	CREATE Action = "create"
+	SKIP   Action = "skip"
+)
+
+// Reasons why a series would not be imported
+const (
+	RESTRICTED    = "restricted"
+	NO_METADATA   = "no metadata"
+	PAST_IMPORT   = "past import year"
+	NO_ROWS       = "no rows"
+	INVALID_INPUT = "invalid input"
+)
+
+// Line of the dry-run report
+type Series struct {
+	Table      string `csv:"table"`
+	Station    int32  `csv:"station"`
+	Series     string `csv:"series"`
+	Timeseries Action `csv:"timeseries"`
+	DataRows   int    `csv:"data_rows"`
+	TextRows   int    `csv:"nonscalar_data_rows"`
+	FlagRows   int    `csv:"flag_rows"`
+	SkipReason string `csv:"skip_reason"`
+}
+
+// Aggregated counts of the series of a table
+type Totals struct {
+	Series   int
+	Actions  map[Action]int
+	Reasons  map[string]int // Number of skipped series by reason
+	DataRows int
+	TextRows int
+	FlagRows int
+}
+
+// Runs the lookup of the LARD timeseries of a series, and returns the action of the import:
+// REUSE if it was found, CREATE if the lookup returned `pgx.ErrNoRows`.
+// Any other error is returned as is
+func TimeseriesAction(lookup func() (int32, error)) (int32, Action, error) {
+	tsid, err := lookup()
+	switch {
+	case err == nil:
+		return tsid, REUSE, nil
+	case errors.Is(err, pgx.ErrNoRows):
+		return tsid, CREATE, nil
+	}
+	return tsid, SKIP, err
+}
+
+// Returns the name of the report file for the given table, similar to the log files.
+// Path separators in the table name (e.g. "kvalobs/data") are replaced, so the report
+// always ends up in the working directory
+func Filename(table string) string {
+	table = strings.ReplaceAll(filepath.ToSlash(table), "/", "_")
+	return fmt.Sprintf("%s_dry_run_%s.csv", table, time.Now().Format(time.RFC3339))
+}
+
+// Collects what an import would do, without touching LARD
+type Report struct {
+	mutex  sync.Mutex
+	series []*Series
+}
+
+func (r *Report) Add(series *Series) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.series = append(r.series, series)
+}
+
+// Returns the totals of each table in the report
+func (r *Report) Totals() map[string]*Totals {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	totals := make(map[string]*Totals)
+	for _, s := range r.series {
+		t, ok := totals[s.Table]
+		if !ok {
+			t = &Totals{Actions: make(map[Action]int), Reasons: make(map[string]int)}
+			totals[s.Table] = t
+		}
+
+		t.Series += 1
+		t.Actions[s.Timeseries] += 1
+		if s.SkipReason != "" {
+			t.Reasons[s.SkipReason] += 1
+		}
+		t.DataRows += s.DataRows
+		t.TextRows += s.TextRows
+		t.FlagRows += s.FlagRows
+	}
+	return totals
+}
+
+// Writes the report sorted by station and series, and prints a summary of each table to stdout
+func (r *Report) Write(filename string) error {
+	totals := r.Totals()
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	slices.SortFunc(r.series, func(a, b *Series) int {
+		return cmp.Or(
+			cmp.Compare(a.Table, b.Table),
+			cmp.Compare(a.Station, b.Station),
+			cmp.Compare(a.Series, b.Series),
+		)
+	})
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := gocsv.Marshal(r.series, file); err != nil {
+		return err
+	}
+
+	tables := make([]string, 0, len(totals))
+	for table := range totals {
+		tables = append(tables, table)
+	}
+	slices.Sort(tables)
+
+	for _, table := range tables {
+		t := totals[table]
+		summary := fmt.Sprintf(
+			"Dry run %s: %d series (%d timeseries reused, %d created, %d skipped), would insert %d data, %d non-scalar data, %d flag rows",
+			table, t.Series, t.Actions[REUSE], t.Actions[CREATE], t.Actions[SKIP], t.DataRows, t.TextRows, t.FlagRows,
+		)
+		fmt.Println(summary)
+		slog.Info(summary)
+
+		reasons := make([]string, 0, len(t.Reasons))
+		for reason := range t.Reasons {
+			reasons = append(reasons, reason)
+		}
+
+		slices.Sort(reasons)
+		for _, reason := range reasons {
+			fmt.Printf("    - %d series skipped: %s\n", t.Reasons[reason], reason)
+		}
+	}
+	fmt.Printf("Report written to %q\n", filename)
+
+	return nil
+}
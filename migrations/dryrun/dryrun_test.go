@@ -0,0 +1,145 @@
+package dryrun
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Stands in for the LARD labels table, mapping stations to their timeseries
+func fakeLookup(timeseries map[int32]int32, station int32) func() (int32, error) {
+	return func() (int32, error) {
+		if station < 0 {
+			return 0, errors.New("connection refused")
+		}
+		tsid, ok := timeseries[station]
+		if !ok {
+			return 0, pgx.ErrNoRows
+		}
+		return tsid, nil
+	}
+}
+
+func TestTimeseriesAction(t *testing.T) {
+	timeseries := map[int32]int32{18700: 1}
+
+	type testCase struct {
+		station  int32
+		tsid     int32
+		expected Action
+		err      bool
+	}
+
+	cases := []testCase{
+		{18700, 1, REUSE, false},
+		{18701, 0, CREATE, false},
+		{-1, 0, SKIP, true},
+	}
+
+	for _, c := range cases {
+		t.Log("Testing station", c.station)
+
+		tsid, action, err := TimeseriesAction(fakeLookup(timeseries, c.station))
+		if (err != nil) != c.err {
+			t.Errorf("Unexpected error: %v", err)
+		}
+		if tsid != c.tsid || action != c.expected {
+			t.Errorf("Got (%d, %s), wanted (%d, %s)", tsid, action, c.tsid, c.expected)
+		}
+	}
+}
+
+func TestFilename(t *testing.T) {
+	for _, table := range []string{"T_ADATA", "kvalobs/data"} {
+		t.Log("Testing", table)
+
+		name := Filename(table)
+		if filepath.Base(name) != name {
+			t.Errorf("Expected a file in the working directory, got %s", name)
+		}
+		if !strings.HasPrefix(name, strings.ReplaceAll(table, "/", "_")+"_dry_run_") {
+			t.Errorf("Unexpected filename %s", name)
+		}
+	}
+}
+
+func TestReport(t *testing.T) {
+	timeseries := map[int32]int32{18700: 1, 18701: 2}
+
+	type input struct {
+		table   string
+		station int32
+		rows    int
+		scalar  bool
+		reason  string
+	}
+
+	inputs := []input{
+		{"T_ADATA", 18700, 10, true, ""},
+		{"T_ADATA", 18702, 5, true, ""},
+		{"T_ADATA", 18703, 0, true, RESTRICTED},
+		{"T_MDATA", 18701, 3, false, ""},
+		{"T_MDATA", 18704, 0, false, NO_METADATA},
+	}
+
+	var report Report
+	for _, in := range inputs {
+		series := &Series{Table: in.table, Station: in.station, Series: "TA", Timeseries: SKIP, SkipReason: in.reason}
+		if in.reason == "" {
+			_, action, err := TimeseriesAction(fakeLookup(timeseries, in.station))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			series.Timeseries = action
+			if in.scalar {
+				series.DataRows = in.rows
+				series.FlagRows = in.rows
+			} else {
+				series.TextRows = in.rows
+			}
+		}
+		report.Add(series)
+	}
+
+	totals := report.Totals()
+
+	adata := totals["T_ADATA"]
+	if adata.Series != 3 || adata.Actions[REUSE] != 1 || adata.Actions[CREATE] != 1 || adata.Actions[SKIP] != 1 {
+		t.Errorf("Unexpected T_ADATA series: %+v", adata)
+	}
+	if adata.DataRows != 15 || adata.FlagRows != 15 || adata.TextRows != 0 || adata.Reasons[RESTRICTED] != 1 {
+		t.Errorf("Unexpected T_ADATA rows: %+v", adata)
+	}
+
+	mdata := totals["T_MDATA"]
+	if mdata.Series != 2 || mdata.Actions[REUSE] != 1 || mdata.Actions[SKIP] != 1 {
+		t.Errorf("Unexpected T_MDATA series: %+v", mdata)
+	}
+	if mdata.DataRows != 0 || mdata.TextRows != 3 || mdata.Reasons[NO_METADATA] != 1 {
+		t.Errorf("Unexpected T_MDATA rows: %+v", mdata)
+	}
+
+	filename := filepath.Join(t.TempDir(), Filename("T_ADATA"))
+	if err := report.Write(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Header and one line per series
+	lines := strings.Split(strings.TrimSpace(string(b)), "\n")
+	if len(lines) != len(inputs)+1 {
+		t.Fatalf("Expected %d lines, got:\n%s", len(inputs)+1, b)
+	}
+	if lines[1] != "T_ADATA,18700,TA,reuse,10,0,10," {
+		t.Errorf("Unexpected first line: %s", lines[1])
+	}
+}
@@ -10,12 +10,13 @@ import (
 	"log/slog"
 	"os"
 	"path/filepath"
-	"slices"
-	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"migrate/utils"
 )
 
 // Format string for date field in CSV files
@@ -24,6 +25,9 @@ const TIMEFORMAT string = "2006-01-02_15:04:05"
 // Error returned if no observations are found for a (station, element) pair
 var EMPTY_QUERY_ERR error = errors.New("The query did not return any rows")
 
+// Error returned if the dumped file exists and should not be overwritten
+var FILE_EXISTS_ERR error = errors.New("dumped file already exists and the --overwrite flag was not provided")
+
 // Struct representing a single record in the output CSV file
 type Record struct {
 	Time time.Time      `db:"time"`
@@ -33,52 +37,108 @@ type Record struct {
 
 func fileExists(filename string) error {
 	if _, err := os.Stat(filename); err == nil {
-		return errors.New(
-			fmt.Sprintf(
-				"Skipping dump of %q because dumped file already exists and the --overwrite flag was not provided",
-				filename,
-			))
+		return fmt.Errorf("Skipping dump of %q because %w", filename, FILE_EXISTS_ERR)
 	}
 	return nil
 }
 
-// Helper function for dumpByYear functinos Fetch min and max year from table, needed for tables that are dumped by year
-func fetchYearRange(tableName, station string, pool *pgxpool.Pool) (int64, int64, error) {
-	var beginStr, endStr string
-	query := fmt.Sprintf("SELECT min(to_char(dato, 'yyyy')), max(to_char(dato, 'yyyy')) FROM %s WHERE stnr = $1", tableName)
+// Returns the file the element should be dumped to and, for incremental dumps,
+// the time after which the rows should be fetched
+func (opts DumpOptions) target(dir, element string) (string, *time.Time, error) {
+	if opts.Incremental {
+		return opts.nextSegment(dir, element)
+	}
 
-	if err := pool.QueryRow(context.TODO(), query, station).Scan(&beginStr, &endStr); err != nil {
-		return 0, 0, fmt.Errorf("Could not query row: %v", err)
+	filename := opts.filename(dir, element)
+	if err := fileExists(filename); err != nil && !opts.Overwrite {
+		return "", nil, err
 	}
+	return filename, nil, nil
+}
 
-	begin, err := strconv.ParseInt(beginStr, 10, 64)
-	if err != nil {
-		return 0, 0, fmt.Errorf("Could not parse year %q: %s", beginStr, err)
+// Returns the start of the first window of the series that needs to be dumped.
+// For incremental dumps this is the window containing the last row already dumped,
+// since the earlier windows cannot contain newer rows
+func (opts DumpOptions) firstWindow(path, element string, window Window, begin time.Time) (time.Time, error) {
+	if !opts.Incremental {
+		return window.Start(begin), nil
 	}
 
-	end, err := strconv.ParseInt(endStr, 10, 64)
-	if err != nil {
-		return 0, 0, fmt.Errorf("Could not parse year %q: %s", endStr, err)
+	last, err := opts.lastDumped(path, element)
+	if err != nil || last == nil || last.Before(begin) {
+		return window.Start(begin), err
 	}
+	return window.Start(*last), nil
+}
 
+// Returns the time of the last row dumped for the element across all the windows of the station directory,
+// which is nil if the element was never dumped
+func (opts DumpOptions) lastDumped(path, element string) (*time.Time, error) {
+	segments, err := ListSegments(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	// Only the segments of the latest window can contain the last row
+	var latest []Segment
+	for _, segment := range segments {
+		if segment.Element != strings.ToUpper(element) {
+			continue
+		}
+		if len(latest) > 0 && segment.Window != latest[0].Window {
+			if segment.Window < latest[0].Window {
+				continue
+			}
+			latest = latest[:0]
+		}
+		latest = append(latest, segment)
+	}
+
+	var last *time.Time
+	for _, segment := range latest {
+		obstime, err := opts.lastObstime(segment.Filename)
+		if err != nil {
+			return nil, err
+		}
+		if last == nil || obstime.After(*last) {
+			last = &obstime
+		}
+	}
+	return last, nil
+}
+
+// Fetches the time of the first and last observation of the station in the table.
+// Returns nil if the station has no observations.
+func fetchTimeRange(ctx context.Context, tableName, station string, pool *pgxpool.Pool) (begin, end *time.Time, err error) {
+	query := fmt.Sprintf("SELECT min(dato), max(dato) FROM %s WHERE stnr = $1", tableName)
+	if err := pool.QueryRow(ctx, query, station).Scan(&begin, &end); err != nil {
+		return nil, nil, fmt.Errorf("Could not query row: %v", err)
+	}
 	return begin, end, nil
 }
 
 // This function is used when the table contains large amount of data
-// (T_SECOND, T_MINUTE, T_10MINUTE)
-func dumpByYear(path string, args dumpArgs, logStr string, overwrite bool, pool *pgxpool.Pool) error {
-	dataBegin, dataEnd, err := fetchYearRange(args.dataTable, args.station, pool)
+// (T_SECOND, T_MINUTE, T_10MINUTE). The series is split in time windows (see `Table.SetWindow`),
+// each one dumped to `station/<window>/element.csv`.
+// During incremental dumps, the windows before the one containing the last dumped row are not queried again,
+// and the newer rows of that window are written to a new segment, so the last window is kept up to date
+func dumpByWindow(ctx context.Context, path string, args dumpArgs, logStr string, opts DumpOptions, pool *pgxpool.Pool) (int64, error) {
+	dataBegin, dataEnd, err := fetchTimeRange(ctx, args.dataTable, args.station, pool)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	flagBegin, flagEnd, err := fetchYearRange(args.flagTable, args.station, pool)
+	flagBegin, flagEnd, err := fetchTimeRange(ctx, args.flagTable, args.station, pool)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	begin := min(dataBegin, flagBegin)
-	end := max(dataEnd, flagEnd)
+	begin, end := earliest(dataBegin, flagBegin), latest(dataEnd, flagEnd)
+	if begin == nil || end == nil {
+		return 0, EMPTY_QUERY_ERR
+	}
 
 	query := fmt.Sprintf(
 		`SELECT
@@ -87,42 +147,86 @@ func dumpByYear(path string, args dumpArgs, logStr string, overwrite bool, pool
             f.%[1]s AS flag
         FROM
             (SELECT dato, stnr, %[1]s FROM %[2]s
-                WHERE %[1]s IS NOT NULL AND stnr = $1 AND TO_CHAR(dato, 'yyyy') = $2) d
+                WHERE %[1]s IS NOT NULL AND stnr = $1 AND dato >= $2 AND dato < $3 AND ($4::timestamp IS NULL OR dato > $4)) d
         FULL OUTER JOIN
             (SELECT dato, stnr, %[1]s FROM %[3]s
-                WHERE %[1]s IS NOT NULL AND stnr = $1 AND TO_CHAR(dato, 'yyyy') = $2) f
-        USING (dato)`,
+                WHERE %[1]s IS NOT NULL AND stnr = $1 AND dato >= $2 AND dato < $3 AND ($4::timestamp IS NULL OR dato > $4)) f
+        USING (dato)
+        ORDER BY dato`,
 		args.element,
 		args.dataTable,
 		args.flagTable,
 	)
 
-	for year := begin; year < end; year++ {
-		yearPath := filepath.Join(path, fmt.Sprint(year))
-		if err := os.MkdirAll(path, os.ModePerm); err != nil {
-			slog.Error(logStr + err.Error())
-			continue
+	first, err := opts.firstWindow(path, args.element, args.window, *begin)
+	if err != nil {
+		return 0, err
+	}
+
+	var rowsWritten int64
+	var existing, windows int
+	var errs []error
+	for start := first; !start.After(*end); start = args.window.Next(start) {
+		if ctx.Err() != nil {
+			return rowsWritten, ctx.Err()
 		}
+		windows++
 
-		filename := filepath.Join(yearPath, args.element+".csv")
-		if err := fileExists(filename); err != nil && !overwrite {
-			slog.Warn(logStr + err.Error())
+		next := args.window.Next(start)
+		filename, after, err := opts.target(filepath.Join(path, args.window.Dirname(start)), args.element)
+		if errors.Is(err, FILE_EXISTS_ERR) {
+			existing++
+			continue
+		} else if err != nil {
+			slog.Error(logStr + err.Error())
+			errs = append(errs, err)
 			continue
 		}
 
-		rows, err := pool.Query(context.TODO(), query, args.station, year)
+		rows, err := pool.Query(ctx, query, args.station, start, next, after)
 		if err != nil {
 			slog.Error(logStr + "Could not query KDVH - " + err.Error())
+			errs = append(errs, err)
 			continue
 		}
 
-		if err := writeToCsv(filename, rows); err != nil {
+		count, err := writeRows(filename, args, opts, rows)
+		if err != nil && !errors.Is(err, EMPTY_QUERY_ERR) {
 			slog.Error(logStr + err.Error())
+			errs = append(errs, err)
 			continue
 		}
+		rowsWritten += count
 	}
 
-	return nil
+	if err := errors.Join(errs...); err != nil {
+		return rowsWritten, err
+	}
+
+	if rowsWritten == 0 {
+		if existing == windows && !opts.Incremental {
+			slog.Warn(logStr + "all the windows were already dumped")
+			return 0, FILE_EXISTS_ERR
+		}
+		return 0, EMPTY_QUERY_ERR
+	}
+	return rowsWritten, nil
+}
+
+// Returns the earliest of the two timestamps, ignoring nil values
+func earliest(a, b *time.Time) *time.Time {
+	if a == nil || b != nil && b.Before(*a) {
+		return b
+	}
+	return a
+}
+
+// Returns the latest of the two timestamps, ignoring nil values
+func latest(a, b *time.Time) *time.Time {
+	if a == nil || b != nil && b.After(*a) {
+		return b
+	}
+	return a
 }
 
 // T_HOMOGEN_MONTH contains seasonal and annual data, plus other derivative
@@ -131,72 +235,112 @@ func dumpByYear(path string, args dumpArgs, logStr string, overwrite bool, pool
 //   - RR (hourly precipitations, note that in Stinfosys this parameter is 'RR_1')
 //
 // We calculate the other data on the fly (outside this program) if needed.
-func dumpHomogenMonth(path string, args dumpArgs, logStr string, overwrite bool, pool *pgxpool.Pool) error {
-	filename := filepath.Join(path, args.element+".csv")
-	if err := fileExists(filename); err != nil && !overwrite {
+func dumpHomogenMonth(ctx context.Context, path string, args dumpArgs, logStr string, opts DumpOptions, pool *pgxpool.Pool) (int64, error) {
+	filename := opts.filename(path, args.element)
+	if err := fileExists(filename); err != nil && !opts.Overwrite {
 		slog.Warn(logStr + err.Error())
-		return err
+		return 0, err
 	}
 
 	query := fmt.Sprintf(
 		`SELECT dato AS time, %s[1]s AS data, '' AS flag FROM T_HOMOGEN_MONTH 
-        WHERE %s[1]s IS NOT NULL AND stnr = $1 AND season BETWEEN 1 AND 12`,
+        WHERE %s[1]s IS NOT NULL AND stnr = $1 AND season BETWEEN 1 AND 12
+        ORDER BY dato`,
 		// NOTE: adding a dummy argument is the only way to suppress this stupid warning
 		args.element, "",
 	)
 
-	rows, err := pool.Query(context.TODO(), query, args.station)
+	rows, err := pool.Query(ctx, query, args.station)
 	if err != nil {
 		slog.Error(logStr + err.Error())
-		return err
+		return 0, err
 	}
 
-	if err := writeToCsv(filename, rows); err != nil {
+	count, err := writeRows(filename, args, opts, rows)
+	if err != nil {
 		slog.Error(logStr + err.Error())
-		return err
+		return 0, err
 	}
 
-	return nil
+	return count, nil
+}
+
+// Same as dumpDataOnly, used to dump the raw values of the quarantined columns (see `Table.Quarantine`).
+// Values are cast to text, since some of these columns are not element codes (e.g. `typeid`)
+func dumpQuarantined(ctx context.Context, path string, args dumpArgs, logStr string, opts DumpOptions, pool *pgxpool.Pool) (int64, error) {
+	filename, after, err := opts.target(path, args.element)
+	if err != nil {
+		slog.Warn(logStr + err.Error())
+		return 0, err
+	}
+
+	query := fmt.Sprintf(
+		`SELECT dato AS time, %[1]s::text AS data, '' AS flag FROM %[2]s
+        WHERE %[1]s IS NOT NULL AND stnr = $1 AND ($2::timestamp IS NULL OR dato > $2)
+        ORDER BY dato`,
+		args.element,
+		args.dataTable,
+	)
+
+	rows, err := pool.Query(ctx, query, args.station, after)
+	if err != nil {
+		slog.Error(logStr + err.Error())
+		return 0, err
+	}
+
+	count, err := writeRows(filename, args, opts, rows)
+	if err != nil {
+		if !errors.Is(err, EMPTY_QUERY_ERR) {
+			slog.Error(logStr + err.Error())
+		}
+		return 0, err
+	}
+
+	return count, nil
 }
 
 // This function is used to dump tables that don't have a FLAG table,
 // (T_METARDATA, T_HOMOGEN_DIURNAL)
-func dumpDataOnly(path string, args dumpArgs, logStr string, overwrite bool, pool *pgxpool.Pool) error {
-	filename := filepath.Join(path, args.element+".csv")
-	if err := fileExists(filename); err != nil && !overwrite {
+func dumpDataOnly(ctx context.Context, path string, args dumpArgs, logStr string, opts DumpOptions, pool *pgxpool.Pool) (int64, error) {
+	filename, after, err := opts.target(path, args.element)
+	if err != nil {
 		slog.Warn(logStr + err.Error())
-		return err
+		return 0, err
 	}
 
 	query := fmt.Sprintf(
 		`SELECT dato AS time, %[1]s AS data, '' AS flag FROM %[2]s 
-        WHERE %[1]s IS NOT NULL AND stnr = $1`,
+        WHERE %[1]s IS NOT NULL AND stnr = $1 AND ($2::timestamp IS NULL OR dato > $2)
+        ORDER BY dato`,
 		args.element,
 		args.dataTable,
 	)
 
-	rows, err := pool.Query(context.TODO(), query, args.station)
+	rows, err := pool.Query(ctx, query, args.station, after)
 	if err != nil {
 		slog.Error(logStr + err.Error())
-		return err
+		return 0, err
 	}
 
-	if err := writeToCsv(filename, rows); err != nil {
-		slog.Error(logStr + err.Error())
-		return err
+	count, err := writeRows(filename, args, opts, rows)
+	if err != nil {
+		if !errors.Is(err, EMPTY_QUERY_ERR) {
+			slog.Error(logStr + err.Error())
+		}
+		return 0, err
 	}
 
-	return nil
+	return count, nil
 }
 
 // This is the default dump function.
 // It selects both data and flag tables for a specific (station, element) pair,
 // and then performs a full outer join on the two subqueries
-func dumpDataAndFlags(path string, args dumpArgs, logStr string, overwrite bool, pool *pgxpool.Pool) error {
-	filename := filepath.Join(path, args.element+".csv")
-	if err := fileExists(filename); err != nil && !overwrite {
+func dumpDataAndFlags(ctx context.Context, path string, args dumpArgs, logStr string, opts DumpOptions, pool *pgxpool.Pool) (int64, error) {
+	filename, after, err := opts.target(path, args.element)
+	if err != nil {
 		slog.Warn(logStr + err.Error())
-		return err
+		return 0, err
 	}
 
 	query := fmt.Sprintf(
@@ -205,89 +349,201 @@ func dumpDataAndFlags(path string, args dumpArgs, logStr string, overwrite bool,
             d.%[1]s AS data,
             f.%[1]s AS flag
         FROM
-            (SELECT dato, %[1]s FROM %[2]s WHERE %[1]s IS NOT NULL AND stnr = $1) d
+            (SELECT dato, %[1]s FROM %[2]s
+                WHERE %[1]s IS NOT NULL AND stnr = $1 AND ($2::timestamp IS NULL OR dato > $2)) d
         FULL OUTER JOIN
-            (SELECT dato, %[1]s FROM %[3]s WHERE %[1]s IS NOT NULL AND stnr = $1) f
-        USING (dato)`,
+            (SELECT dato, %[1]s FROM %[3]s
+                WHERE %[1]s IS NOT NULL AND stnr = $1 AND ($2::timestamp IS NULL OR dato > $2)) f
+        USING (dato)
+        ORDER BY dato`,
 		args.element,
 		args.dataTable,
 		args.flagTable,
 	)
 
-	rows, err := pool.Query(context.TODO(), query, args.station)
+	rows, err := pool.Query(ctx, query, args.station, after)
 	if err != nil {
 		slog.Error(logStr + err.Error())
-		return err
+		return 0, err
 	}
 
-	if err := writeToCsv(filename, rows); err != nil {
+	count, err := writeRows(filename, args, opts, rows)
+	if err != nil {
 		if !errors.Is(err, EMPTY_QUERY_ERR) {
 			slog.Error(logStr + err.Error())
 		}
-		return err
+		return 0, err
 	}
 
-	return nil
+	return count, nil
 }
 
-// Dumps queried rows to file
-func writeToCsv(filename string, rows pgx.Rows) error {
-	lines, err := sortRows(rows)
-	if err != nil {
-		return err
+// Streams the queried rows to a file in the selected format, returning the number of rows written
+func writeRows(filename string, args dumpArgs, opts DumpOptions, rows pgx.Rows) (int64, error) {
+	if opts.Format == utils.PARQUET {
+		return writeToParquet(filename, args, opts, rows)
 	}
+	return writeToCsv(filename, args, opts, rows)
+}
 
-	// Return if query was empty
-	if len(lines) == 0 {
-		return EMPTY_QUERY_ERR
+// Streams the queried rows to file, returning the number of rows written.
+// The rows need to be sorted by the query. Since the number of rows is only known at the end,
+// it is written in a trailer line, so the series never needs to be kept in memory.
+// The file is only created if the query returned some rows, and it is removed if it
+// could not be written completely or added to the dump manifest
+func writeToCsv(filename string, args dumpArgs, opts DumpOptions, rows pgx.Rows) (count int64, err error) {
+	defer rows.Close()
+
+	var file io.WriteCloser
+	var writer *csv.Writer
+	var from, to time.Time
+	defer func() {
+		if file == nil {
+			return
+		}
+
+		if err == nil {
+			writer.Flush()
+			err = writer.Error()
+		}
+		if err == nil {
+			_, err = fmt.Fprintf(file, "%s%d\n", TRAILER_PREFIX, count)
+		}
+		if closeErr := file.Close(); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+		if err == nil {
+			err = opts.record(filename, args, from, to, count)
+		}
+
+		if err != nil {
+			os.Remove(filename)
+			count = 0
+		}
+	}()
+
+	var record Record
+	line := make([]string, 3)
+	for rows.Next() {
+		if err := rows.Scan(&record.Time, &record.Data, &record.Flag); err != nil {
+			return count, errors.New("Could not scan row: " + err.Error())
+		}
+
+		if file == nil {
+			// Time window directories are only created if they contain some rows
+			if err = os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+				return 0, err
+			}
+			if file, err = utils.CreateCompressed(filename, opts.Compression); err != nil {
+				return 0, err
+			}
+			writer = csv.NewWriter(file)
+			from = record.Time
+		}
+		to = record.Time
+
+		line[0] = record.Time.Format(TIMEFORMAT)
+		line[1] = record.Data.String
+		line[2] = record.Flag.String
+		if err := writer.Write(line); err != nil {
+			return count, errors.New("Could not write to file: " + err.Error())
+		}
+		count++
 	}
 
-	file, err := os.Create(filename)
-	if err != nil {
-		return err
+	if err := rows.Err(); err != nil {
+		return count, err
 	}
 
-	err = writeElementFile(lines, file)
-	if closeErr := file.Close(); closeErr != nil {
-		return errors.Join(err, closeErr)
+	// Return if query was empty
+	if count == 0 {
+		return 0, EMPTY_QUERY_ERR
 	}
-	return err
+	return count, nil
 }
 
-// Scans the rows and collects them in a slice of chronologically sorted lines
-func sortRows(rows pgx.Rows) ([]Record, error) {
-	defer rows.Close()
+// Row of the dumped Parquet files
+type parquetRecord struct {
+	Time time.Time `parquet:"time,timestamp(millisecond)"`
+	Data *string   `parquet:"data"`
+	Flag *string   `parquet:"flag"`
+}
 
-	records, err := pgx.CollectRows(rows, pgx.RowToStructByName[Record])
-	if err != nil {
-		return nil, errors.New("Could not collect rows: " + err.Error())
+func (r *parquetRecord) toObs() KdvhObs {
+	obs := KdvhObs{Obstime: r.Time}
+	if r.Data != nil {
+		obs.Data = *r.Data
+	}
+	if r.Flag != nil {
+		obs.Flags = *r.Flag
 	}
+	return obs
+}
 
-	slices.SortFunc(records, func(a, b Record) int {
-		return a.Time.Compare(b.Time)
-	})
+// Same as writeToCsv, but writes the rows to a Parquet file with typed columns.
+// The number of rows is stored in the file metadata, so no trailer is needed
+func writeToParquet(filename string, args dumpArgs, opts DumpOptions, rows pgx.Rows) (count int64, err error) {
+	defer rows.Close()
 
-	return records, rows.Err()
-}
+	var writer *utils.ParquetWriter[parquetRecord]
+	var from, to time.Time
+	defer func() {
+		if writer == nil {
+			return
+		}
 
-// Writes queried (time | data | flag) columns to CSV
-func writeElementFile(lines []Record, file io.Writer) error {
-	// Write number of lines as header
-	file.Write([]byte(fmt.Sprintf("%v\n", len(lines))))
+		if closeErr := writer.Close(); closeErr != nil {
+			err = errors.Join(err, closeErr)
+		}
+		if err == nil {
+			err = opts.record(filename, args, from, to, count)
+		}
 
-	writer := csv.NewWriter(file)
+		if err != nil {
+			os.Remove(filename)
+			count = 0
+		}
+	}()
+
+	var record Record
+	line := make([]parquetRecord, 1)
+	for rows.Next() {
+		if err := rows.Scan(&record.Time, &record.Data, &record.Flag); err != nil {
+			return count, errors.New("Could not scan row: " + err.Error())
+		}
 
-	record := make([]string, 3)
-	for _, l := range lines {
-		record[0] = l.Time.Format(TIMEFORMAT)
-		record[1] = l.Data.String
-		record[2] = l.Flag.String
+		if writer == nil {
+			// Time window directories are only created if they contain some rows
+			if err = os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+				return 0, err
+			}
+			if writer, err = utils.CreateParquet[parquetRecord](filename, opts.Compression); err != nil {
+				return 0, err
+			}
+			from = record.Time
+		}
+		to = record.Time
 
-		if err := writer.Write(record); err != nil {
-			return errors.New("Could not write to file: " + err.Error())
+		line[0] = parquetRecord{Time: record.Time}
+		if record.Data.Valid {
+			line[0].Data = &record.Data.String
+		}
+		if record.Flag.Valid {
+			line[0].Flag = &record.Flag.String
 		}
+		if _, err := writer.Write(line); err != nil {
+			return count, errors.New("Could not write to file: " + err.Error())
+		}
+		count++
 	}
 
-	writer.Flush()
-	return writer.Error()
+	if err := rows.Err(); err != nil {
+		return count, err
+	}
+
+	// Return if query was empty
+	if count == 0 {
+		return 0, EMPTY_QUERY_ERR
+	}
+	return count, nil
 }
@@ -0,0 +1,158 @@
+package db
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// Name of the snapshot of the ELEM table, saved by `kdvh dump` in the directory of the dumped table
+const ELEM_FILENAME = "elem_metadata.csv"
+
+// Row of the ELEM table for a (station, element) pair of the KDVH table
+type ElemRow struct {
+	Station       int32      `db:"stnr"`
+	Element       string     `db:"elem_code"`
+	Fdato         *time.Time `db:"fdato"`
+	Tdato         *time.Time `db:"tdato"`
+	FlagTableName *string    `db:"flag_table_name"`
+}
+
+// Columns of the snapshot, NULL values are written as empty fields
+var ELEM_COLUMNS = []string{"stnr", "elem_code", "fdato", "tdato", "flag_table_name"}
+
+func (row *ElemRow) toRecord() []string {
+	var flagTable string
+	if row.FlagTableName != nil {
+		flagTable = *row.FlagTableName
+	}
+
+	return []string{
+		strconv.Itoa(int(row.Station)),
+		row.Element,
+		formatNullTime(row.Fdato),
+		formatNullTime(row.Tdato),
+		flagTable,
+	}
+}
+
+func elemFromRecord(record []string) (row ElemRow, err error) {
+	if len(record) != len(ELEM_COLUMNS) {
+		return row, fmt.Errorf("expected %d columns, got %d", len(ELEM_COLUMNS), len(record))
+	}
+
+	station, err := strconv.ParseInt(record[0], 10, 32)
+	if err != nil {
+		return row, err
+	}
+	row.Station = int32(station)
+	row.Element = record[1]
+
+	if row.Fdato, err = parseNullTime(record[2]); err != nil {
+		return row, err
+	}
+	if row.Tdato, err = parseNullTime(record[3]); err != nil {
+		return row, err
+	}
+	if record[4] != "" {
+		row.FlagTableName = &record[4]
+	}
+	return row, nil
+}
+
+func formatNullTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.RFC3339)
+}
+
+func parseNullTime(s string) (*time.Time, error) {
+	if s == "" {
+		return nil, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	return &t, err
+}
+
+// Saves the rows of the ELEM table that refer to this table in `dir`,
+// so the timespans can be read during import without connecting to KDVH
+func (table *Table) DumpElem(ctx context.Context, dir string, pool *pgxpool.Pool) error {
+	if table.ElemTableName == "" {
+		return nil
+	}
+
+	// TODO: probably need to sanitize these inputs
+	query := fmt.Sprintf(
+		`SELECT stnr, elem_code, fdato, tdato, flag_table_name FROM %s
+            WHERE table_name = $1
+            ORDER BY stnr, elem_code`,
+		strings.ToLower(table.ElemTableName),
+	)
+
+	rows, err := pool.Query(ctx, query, table.TableName)
+	if err != nil {
+		return err
+	}
+
+	elems, err := pgx.CollectRows(rows, pgx.RowToStructByName[ElemRow])
+	if err != nil {
+		return err
+	}
+
+	filename := filepath.Join(dir, ELEM_FILENAME)
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(file)
+	writer.Write(ELEM_COLUMNS)
+	for _, elem := range elems {
+		writer.Write(elem.toRecord())
+	}
+	writer.Flush()
+
+	err = writer.Error()
+	if closeErr := file.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	if err != nil {
+		os.Remove(filename)
+	}
+	return err
+}
+
+// Reads the snapshot of the ELEM table saved in `dir` by `DumpElem`
+func ReadElem(dir string) ([]ElemRow, error) {
+	file, err := os.Open(filepath.Join(dir, ELEM_FILENAME))
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	records, err := csv.NewReader(file).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", file.Name(), err)
+	}
+
+	// Skip the column names
+	elems := make([]ElemRow, 0, max(len(records)-1, 0))
+	for i, record := range records[min(len(records), 1):] {
+		elem, err := elemFromRecord(record)
+		if err != nil {
+			return nil, fmt.Errorf("%s: line %d: %w", file.Name(), i+2, err)
+		}
+		elems = append(elems, elem)
+	}
+	return elems, nil
+}
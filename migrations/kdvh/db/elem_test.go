@@ -0,0 +1,34 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestReadElem(t *testing.T) {
+	dir := t.TempDir()
+	content := "stnr,elem_code,fdato,tdato,flag_table_name\n" +
+		"18700,TA,1937-01-01T06:00:00Z,,T_AFLAG\n" +
+		"18700,TAX,,2005-12-31T18:00:00Z,\n"
+	if err := os.WriteFile(filepath.Join(dir, ELEM_FILENAME), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	elems, err := ReadElem(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(elems) != 2 {
+		t.Fatalf("Got %v rows, wanted 2", len(elems))
+	}
+
+	from := time.Date(1937, 1, 1, 6, 0, 0, 0, time.UTC)
+	if elems[0].Station != 18700 || elems[0].Element != "TA" || elems[0].Fdato == nil || !elems[0].Fdato.Equal(from) || elems[0].Tdato != nil {
+		t.Errorf("Got %+v, wanted TA from %v", elems[0], from)
+	}
+	if elems[1].Fdato != nil || elems[1].Tdato == nil || elems[1].FlagTableName != nil {
+		t.Errorf("Got %+v, wanted TAX with only tdato", elems[1])
+	}
+}
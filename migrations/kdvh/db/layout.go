@@ -0,0 +1,244 @@
+package db
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"migrate/utils"
+)
+
+// Dumped files contain one observation per line, followed by a trailer line
+// with the number of observations, e.g. `# rows: 2644`.
+// Older dumps store the number of observations on the first line instead.
+const TRAILER_PREFIX string = "# rows: "
+
+// Error returned if a file without header does not end with the trailer, usually because the dump was interrupted
+var MISSING_TRAILER_ERR error = errors.New("missing row count trailer, the file might be truncated")
+
+// Parses the row count on the first line of older dumps
+func ParseHeader(line string) (int64, bool) {
+	count, err := strconv.ParseInt(strings.TrimSpace(line), 10, 64)
+	return count, err == nil
+}
+
+// Parses the row count on the last line of dumps
+func ParseTrailer(line string) (int64, bool) {
+	value, found := strings.CutPrefix(line, TRAILER_PREFIX)
+	if !found {
+		return 0, false
+	}
+	count, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	return count, err == nil
+}
+
+// Reads the observation lines of a dumped file, with either layout
+type DumpReader struct {
+	scanner  *bufio.Scanner
+	line     string
+	read     int64
+	expected int64
+	started  bool
+	header   bool
+	trailer  bool
+}
+
+func NewDumpReader(r io.Reader) *DumpReader {
+	return &DumpReader{scanner: bufio.NewScanner(r)}
+}
+
+// Advances to the next observation line, returning false at the end of the file
+func (r *DumpReader) Next() bool {
+	if r.trailer {
+		return false
+	}
+
+	for r.scanner.Scan() {
+		line := r.scanner.Text()
+
+		if !r.started {
+			r.started = true
+			if count, ok := ParseHeader(line); ok {
+				r.header = true
+				r.expected = count
+				continue
+			}
+		}
+
+		if count, ok := ParseTrailer(line); ok {
+			r.trailer = true
+			r.expected = count
+			return false
+		}
+
+		r.line = line
+		r.read++
+		return true
+	}
+	return false
+}
+
+// Current observation line
+func (r *DumpReader) Line() string {
+	return r.line
+}
+
+// Number of observations stored in the header, zero if the file has no header
+func (r *DumpReader) HeaderCount() int64 {
+	if r.header {
+		return r.expected
+	}
+	return 0
+}
+
+// Checks that the whole file was read correctly, should be called after `Next` returns false
+func (r *DumpReader) Err() error {
+	if err := r.scanner.Err(); err != nil {
+		return err
+	}
+
+	// Counts in the header of older dumps were never checked
+	if r.header {
+		return nil
+	}
+
+	if !r.trailer {
+		return MISSING_TRAILER_ERR
+	}
+
+	if r.read != r.expected {
+		return fmt.Errorf("read %d rows, but the trailer reports %d", r.read, r.expected)
+	}
+	return nil
+}
+
+// Counts the observations in a dumped file, checking them against the header or the trailer
+// of CSV files. Parquet files store the number of rows in their metadata.
+func CountRows(filename string) (int64, error) {
+	if utils.IsParquet(filename) {
+		return utils.CountParquetRows(filename)
+	}
+
+	file, err := utils.OpenDecompressed(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	reader := NewDumpReader(file)
+	for reader.Next() {
+	}
+
+	if err := reader.Err(); err != nil {
+		return reader.read, err
+	}
+
+	if reader.header && reader.read != reader.expected {
+		return reader.read, fmt.Errorf("read %d rows, but the header reports %d", reader.read, reader.expected)
+	}
+	return reader.read, nil
+}
+
+// Reads the observations of a dumped file, in any of the supported formats
+type ObsReader interface {
+	// Advances to the next observation, returning false at the end of the file
+	Next() bool
+	// Parses the current observation
+	Obs() (*KdvhObs, error)
+	// Expected number of observations, zero if unknown
+	SizeHint() int
+	// Checks that the whole file was read correctly, should be called after `Next` returns false
+	Err() error
+	Close() error
+}
+
+// Opens the dumped file. `sep` is the separator of the CSV files
+func OpenObsReader(filename, sep string) (ObsReader, error) {
+	if utils.IsParquet(filename) {
+		reader, err := utils.OpenParquet[parquetRecord](filename)
+		if err != nil {
+			return nil, err
+		}
+		return &parquetObsReader{reader: reader, rows: make([]parquetRecord, 1024)}, nil
+	}
+
+	file, err := utils.OpenDecompressed(filename)
+	if err != nil {
+		return nil, err
+	}
+	return &csvObsReader{NewDumpReader(file), file, sep}, nil
+}
+
+type csvObsReader struct {
+	*DumpReader
+	file io.Closer
+	sep  string
+}
+
+func (r *csvObsReader) Obs() (*KdvhObs, error) {
+	cols := strings.Split(r.Line(), r.sep)
+	if len(cols) < 3 {
+		return nil, fmt.Errorf("expected 3 columns separated by %q, got %q", r.sep, r.Line())
+	}
+
+	obstime, err := time.Parse(TIMEFORMAT, cols[0])
+	if err != nil {
+		return nil, err
+	}
+	return &KdvhObs{Obstime: obstime, Data: cols[1], Flags: cols[2]}, nil
+}
+
+func (r *csvObsReader) SizeHint() int {
+	return int(r.HeaderCount())
+}
+
+func (r *csvObsReader) Close() error {
+	return r.file.Close()
+}
+
+type parquetObsReader struct {
+	reader *utils.ParquetReader[parquetRecord]
+	rows   []parquetRecord
+	index  int
+	n      int
+	err    error
+}
+
+func (r *parquetObsReader) Next() bool {
+	r.index++
+	if r.index < r.n {
+		return true
+	}
+	if r.err != nil {
+		return false
+	}
+
+	// Read the next batch of rows
+	r.n, r.err = r.reader.Read(r.rows)
+	r.index = 0
+	return r.n > 0
+}
+
+func (r *parquetObsReader) Obs() (*KdvhObs, error) {
+	obs := r.rows[r.index].toObs()
+	return &obs, nil
+}
+
+func (r *parquetObsReader) SizeHint() int {
+	return int(r.reader.NumRows())
+}
+
+func (r *parquetObsReader) Err() error {
+	if errors.Is(r.err, io.EOF) {
+		return nil
+	}
+	return r.err
+}
+
+func (r *parquetObsReader) Close() error {
+	return r.reader.Close()
+}
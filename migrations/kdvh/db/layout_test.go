@@ -0,0 +1,104 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"migrate/utils"
+)
+
+func TestDumpReader(t *testing.T) {
+	type testCase struct {
+		name    string
+		content string
+		lines   int
+		err     error
+	}
+
+	cases := []testCase{
+		{"header", "2\n2001-07-01_09:00:00,12.9,70000\n2001-07-01_10:00:00,13.1,70000\n", 2, nil},
+		{"trailer", "2001-07-01_09:00:00,12.9,70000\n2001-07-01_10:00:00,13.1,70000\n# rows: 2\n", 2, nil},
+		{"truncated", "2001-07-01_09:00:00,12.9,70000\n2001-07-01_10:00:00,13.1,700", 2, MISSING_TRAILER_ERR},
+	}
+
+	for _, c := range cases {
+		t.Log("Testing layout:", c.name)
+
+		reader := NewDumpReader(strings.NewReader(c.content))
+		var lines int
+		for reader.Next() {
+			if strings.HasPrefix(reader.Line(), "#") {
+				t.Errorf("Got trailer %q as observation line", reader.Line())
+			}
+			lines++
+		}
+
+		if lines != c.lines {
+			t.Errorf("Got %v lines, wanted %v", lines, c.lines)
+		}
+		if err := reader.Err(); !errors.Is(err, c.err) {
+			t.Errorf("Got error %v, wanted %v", err, c.err)
+		}
+	}
+
+	reader := NewDumpReader(strings.NewReader("2001-07-01_09:00:00,12.9,70000\n# rows: 3\n"))
+	for reader.Next() {
+	}
+	if reader.Err() == nil {
+		t.Error("Expected error for mismatched trailer count")
+	}
+}
+
+func TestParquetObsReader(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "TA.parquet")
+	writer, err := utils.CreateParquet[parquetRecord](filename, utils.ZSTD)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// More rows than a single read batch
+	start := time.Date(2001, 7, 1, 0, 0, 0, 0, time.UTC)
+	for i := range 3000 {
+		data, flag := fmt.Sprint(i), "70000"
+		if _, err := writer.Write([]parquetRecord{{Time: start.Add(time.Duration(i) * time.Hour), Data: &data, Flag: &flag}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reader, err := OpenObsReader(filename, ",")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+
+	if reader.SizeHint() != 3000 {
+		t.Errorf("Got size hint %v, wanted 3000", reader.SizeHint())
+	}
+
+	var i int
+	for reader.Next() {
+		obs, err := reader.Obs()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !obs.Obstime.Equal(start.Add(time.Duration(i)*time.Hour)) || obs.Data != fmt.Sprint(i) || obs.Flags != "70000" {
+			t.Fatalf("Got %+v for row %v", obs, i)
+		}
+		i++
+	}
+	if err := reader.Err(); err != nil || i != 3000 {
+		t.Errorf("Got (%v, %v), wanted 3000 rows", i, err)
+	}
+
+	last, err := LastObstime(filename)
+	if err != nil || !last.Equal(start.Add(2999*time.Hour)) {
+		t.Errorf("Got (%v, %v), wanted %v", last, err, start.Add(2999*time.Hour))
+	}
+}
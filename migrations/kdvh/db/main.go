@@ -3,6 +3,7 @@ package db
 import (
 	"migrate/stinfosys"
 	"migrate/utils"
+	"slices"
 	"time"
 
 	"github.com/rickb777/period"
@@ -10,6 +11,12 @@ import (
 
 const KDVH_ENV_VAR string = "KDVH_PROXY_CONN_STRING"
 
+// Env variables with the connection strings of the databases of the tables missing in the KDVH proxy
+const (
+	AVINOR_ENV_VAR   string = "KDVH_AVINOR_CONN_STRING"
+	PROJDATA_ENV_VAR string = "KDVH_PROJDATA_CONN_STRING"
+)
+
 // Map of all tables found in KDVH, with set max import year
 type KDVH struct {
 	Tables map[string]*Table
@@ -19,23 +26,23 @@ func Init() *KDVH {
 	return &KDVH{map[string]*Table{
 		// Section 1: tables that need to be migrated entirely
 		// TODO: figure out if we need to use the elem_code_paramid_level_sensor_t_edata table?
-		"T_EDATA":     NewTable("T_EDATA", "T_EFLAG", "T_ELEM_EDATA").SetConvertFunc(convertEdata).SetImportYear(3000),
+		"T_EDATA":     NewTable("T_EDATA", "T_EFLAG", "T_ELEM_EDATA").SetFlagRules(DEFAULT_FLAG_RULES).SetImportYear(3000),
 		"T_METARDATA": NewTable("T_METARDATA", "", "T_ELEM_METARDATA").SetDumpFunc(dumpDataOnly).SetImportYear(3000),
 
 		// Section 2: tables with some data in kvalobs, import only up to 2005-12-31
 		"T_ADATA":      NewTable("T_ADATA", "T_AFLAG", "T_ELEM_OBS").SetImportYear(2006),
 		"T_MDATA":      NewTable("T_MDATA", "T_MFLAG", "T_ELEM_OBS").SetImportYear(2006),
 		"T_TJ_DATA":    NewTable("T_TJ_DATA", "T_TJ_FLAG", "T_ELEM_OBS").SetImportYear(2006),
-		"T_PDATA":      NewTable("T_PDATA", "T_PFLAG", "T_ELEM_OBS").SetConvertFunc(convertPdata).SetImportYear(2006),
-		"T_NDATA":      NewTable("T_NDATA", "T_NFLAG", "T_ELEM_OBS").SetConvertFunc(convertNdata).SetImportYear(2006),
+		"T_PDATA":      NewTable("T_PDATA", "T_PFLAG", "T_ELEM_OBS").SetFlagRules(DEFAULT_FLAG_RULES).SetImportYear(2006),
+		"T_NDATA":      NewTable("T_NDATA", "T_NFLAG", "T_ELEM_OBS").SetFlagRules(DEFAULT_FLAG_RULES).SetImportYear(2006),
 		"T_VDATA":      NewTable("T_VDATA", "T_VFLAG", "T_ELEM_OBS").SetConvertFunc(convertVdata).SetImportYear(2006),
 		"T_UTLANDDATA": NewTable("T_UTLANDDATA", "T_UTLANDFLAG", "T_ELEM_OBS").SetImportYear(2006),
 
 		// Section 3: tables that should only be dumped
-		"T_10MINUTE_DATA": NewTable("T_10MINUTE_DATA", "T_10MINUTE_FLAG", "T_ELEM_OBS").SetDumpFunc(dumpByYear),
+		"T_10MINUTE_DATA": NewTable("T_10MINUTE_DATA", "T_10MINUTE_FLAG", "T_ELEM_OBS").SetWindow(YEAR),
 		"T_ADATA_LEVEL":   NewTable("T_ADATA_LEVEL", "T_AFLAG_LEVEL", "T_ELEM_OBS"),
-		"T_MINUTE_DATA":   NewTable("T_MINUTE_DATA", "T_MINUTE_FLAG", "T_ELEM_OBS").SetDumpFunc(dumpByYear),
-		"T_SECOND_DATA":   NewTable("T_SECOND_DATA", "T_SECOND_FLAG", "T_ELEM_OBS").SetDumpFunc(dumpByYear),
+		"T_MINUTE_DATA":   NewTable("T_MINUTE_DATA", "T_MINUTE_FLAG", "T_ELEM_OBS").SetWindow(YEAR),
+		"T_SECOND_DATA":   NewTable("T_SECOND_DATA", "T_SECOND_FLAG", "T_ELEM_OBS").SetWindow(MONTH),
 		"T_CDCV_DATA":     NewTable("T_CDCV_DATA", "T_CDCV_FLAG", "T_ELEM_EDATA"),
 		"T_MERMAID":       NewTable("T_MERMAID", "T_MERMAID_FLAG", "T_ELEM_EDATA"),
 		"T_SVVDATA":       NewTable("T_SVVDATA", "T_SVVFLAG", "T_ELEM_OBS"),
@@ -49,14 +56,27 @@ func Init() *KDVH {
 
 		// Section 5: tables missing in the KDVH proxy:
 		// 1. these exist in a separate database
-		"T_AVINOR":   NewTable("T_AVINOR", "T_AVINOR_FLAG", "T_ELEM_OBS"),
-		"T_PROJDATA": NewTable("T_PROJDATA", "T_PROJFLAG", "T_ELEM_PROJ"),
+		"T_AVINOR":   NewTable("T_AVINOR", "T_AVINOR_FLAG", "T_ELEM_OBS").SetSource(AVINOR_ENV_VAR),
+		"T_PROJDATA": NewTable("T_PROJDATA", "T_PROJFLAG", "T_ELEM_PROJ").SetSource(PROJDATA_ENV_VAR),
 		// 2. these are not in active use and don't need to be imported in LARD
 		"T_DIURNAL_INTERPOLATED": NewTable("T_DIURNAL_INTERPOLATED", "", "").SetConvertFunc(convertDiurnalInterpolated),
 		"T_MONTH_INTERPOLATED":   NewTable("T_MONTH_INTERPOLATED", "", ""),
 	}}
 }
 
+// Returns the env variables with the connection strings needed to dump the selected tables, sorted.
+// If no table is selected, only the KDVH proxy is required, since the other sources are optional
+func (k *KDVH) EnvVars(tables []string) []string {
+	vars := []string{KDVH_ENV_VAR}
+	for _, name := range tables {
+		if table, ok := k.Tables[name]; ok && !slices.Contains(vars, table.ConnEnvVar) {
+			vars = append(vars, table.ConnEnvVar)
+		}
+	}
+	slices.Sort(vars)
+	return vars
+}
+
 // Struct that represent an observation in KDVH
 type KdvhObs struct {
 	Obstime time.Time
@@ -66,11 +86,12 @@ type KdvhObs struct {
 
 // Convenience struct that holds information for a specific timeseries
 type TsInfo struct {
-	Id       int32
-	Station  int32
-	Element  string
-	Offset   period.Period
-	Param    stinfosys.Param
-	Timespan utils.TimeSpan
-	Logstr   string
+	Id        int32
+	Station   int32
+	Element   string
+	Offset    period.Period
+	Param     stinfosys.Param
+	Timespan  utils.TimeSpan
+	Transform *Transform // Applied by the ConvertFunctions, nil for most series, see TRANSFORMS
+	Logstr    string
 }
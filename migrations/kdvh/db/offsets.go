@@ -0,0 +1,71 @@
+package db
+
+import (
+	"bytes"
+	_ "embed"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/gocarina/gocsv"
+	"github.com/rickb777/period"
+)
+
+// Offsets used when no offsets file is passed
+//
+//go:embed product_offsets.csv
+var defaultOffsets []byte
+
+// Row of `product_offsets.csv`, with the offsets used to correct the KDVH obstimes of some products
+type OffsetRow struct {
+	TableName      string `csv:"table_name"`
+	ElemCode       string `csv:"elem_code"`
+	ParamID        int32  `csv:"paramid"`
+	FromtimeOffset string `csv:"fromtime_offset"` // ISO-8601 period, can be empty
+	Timespan       string `csv:"timespan"`        // ISO-8601 period, can be empty
+}
+
+// Line of the row in the CSV file, given its index
+func OffsetLine(index int) int {
+	// The first line is the header
+	return index + 2
+}
+
+// Reads the rows of the given offsets file, or of the embedded one if the filename is empty.
+// The periods are not parsed, see `OffsetRow.Offset`
+func ReadOffsetRows(filename string) ([]OffsetRow, error) {
+	var reader io.Reader = bytes.NewReader(defaultOffsets)
+	if filename != "" {
+		file, err := os.Open(filename)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	var rows []OffsetRow
+	if err := gocsv.Unmarshal(reader, &rows); err != nil {
+		return nil, err
+	}
+	return rows, nil
+}
+
+// Parses the periods of the row and returns their sum,
+// i.e. the offset added to the KDVH obstimes of the element
+func (row *OffsetRow) Offset() (period.Period, error) {
+	var fromtimeOffset, timespan period.Period
+	var err error
+
+	if row.FromtimeOffset != "" {
+		if fromtimeOffset, err = period.Parse(row.FromtimeOffset); err != nil {
+			return period.Period{}, fmt.Errorf("fromtime_offset: %w", err)
+		}
+	}
+	if row.Timespan != "" {
+		if timespan, err = period.Parse(row.Timespan); err != nil {
+			return period.Period{}, fmt.Errorf("timespan: %w", err)
+		}
+	}
+	return fromtimeOffset.Add(timespan)
+}
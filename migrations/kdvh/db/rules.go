@@ -0,0 +1,331 @@
+package db
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"migrate/lard"
+)
+
+// Rules used when no rules file is passed, see `flag_rules.csv` for the format
+//
+//go:embed flag_rules.csv
+var defaultFlagRules []byte
+
+// Parsed once, the embedded file is checked by the tests
+var DEFAULT_FLAG_RULES = mustParseFlagRules(defaultFlagRules)
+
+// Flag of the rules that match the codes without their own rule
+const ANY_FLAG = "*"
+
+var (
+	FLAG_RULES_VERSION_ERR error = errors.New("Missing '# version: ' line in flag rules")
+	NO_FLAG_RULE_ERR       error = errors.New("No flag rule matches the observation")
+)
+
+var FLAG_RULES_HEADER = []string{"table", "flag", "value", "controlinfo", "useinfo"}
+
+// Named flags that can be used in the rules file instead of literals
+var (
+	CONTROLINFO_NAMES = map[string]string{
+		"VALUE_PASSED_QC":               VALUE_PASSED_QC,
+		"VALUE_CORRECTED_AUTOMATICALLY": VALUE_CORRECTED_AUTOMATICALLY,
+		"VALUE_MANUALLY_INTERPOLATED":   VALUE_MANUALLY_INTERPOLATED,
+		"VALUE_MANUALLY_ASSIGNED":       VALUE_MANUALLY_ASSIGNED,
+		"VALUE_REMOVED_BY_QC":           VALUE_REMOVED_BY_QC,
+		"VALUE_MISSING":                 VALUE_MISSING,
+		"VALUE_PASSED_HQC":              VALUE_PASSED_HQC,
+		"INTERPOLATION_ADDED_MANUALLY":  INTERPOLATION_ADDED_MANUALLY,
+	}
+	USEINFO_NAMES = map[string]string{
+		"INVALID_FLAGS":                INVALID_FLAGS,
+		"COMPLETED_HQC":                COMPLETED_HQC,
+		"DIURNAL_INTERPOLATED_USEINFO": DIURNAL_INTERPOLATED_USEINFO,
+	}
+)
+
+// Kvalobs flags are 16 hexadecimal digits
+var flagRegex = regexp.MustCompile(`^[0-9A-F]{16}$`)
+
+// Whether the value of the observation can be parsed as a number
+type ValueState string
+
+const (
+	PRESENT ValueState = "present"
+	MISSING ValueState = "missing"
+)
+
+func (v *ValueState) UnmarshalText(text []byte) error {
+	switch s := ValueState(text); s {
+	case PRESENT, MISSING:
+		*v = s
+		return nil
+	}
+	return fmt.Errorf("value must be %q or %q, got %q", PRESENT, MISSING, text)
+}
+
+// Checks if the value of the observation can be parsed as a number, see ValueState
+func (obs *KdvhObs) HasValue() bool {
+	_, err := strconv.ParseFloat(obs.Data, 32)
+	return err == nil
+}
+
+func valueState(present bool) ValueState {
+	if present {
+		return PRESENT
+	}
+	return MISSING
+}
+
+// Line of the rules file
+type FlagRule struct {
+	Table       string
+	Flag        string // 5-digit KDVH flag or ANY_FLAG
+	Value       ValueState
+	Controlinfo string
+	Useinfo     string // Optional, by default derived from the KDVH flag
+}
+
+type ruleKey struct {
+	table string
+	flag  string
+	value ValueState
+}
+
+// Rules used to convert the KDVH flags of some tables to Kvalobs flags, see `flag_rules.csv`
+type FlagRules struct {
+	Version string
+	rules   map[ruleKey]FlagRule
+}
+
+// Reads the rules from the given file, or returns the embedded ones if the filename is empty
+func ReadFlagRules(filename string) (*FlagRules, error) {
+	if filename == "" {
+		return DEFAULT_FLAG_RULES, nil
+	}
+
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	rules, err := ParseFlagRules(content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return rules, nil
+}
+
+func mustParseFlagRules(content []byte) *FlagRules {
+	rules, err := ParseFlagRules(content)
+	if err != nil {
+		panic("Invalid embedded flag rules: " + err.Error())
+	}
+	return rules
+}
+
+// Parses a rules file, checking that all the flags are valid and that each rule is unique
+func ParseFlagRules(content []byte) (*FlagRules, error) {
+	rules := &FlagRules{rules: make(map[ruleKey]FlagRule)}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		if version, ok := strings.CutPrefix(scanner.Text(), "# version:"); ok {
+			rules.Version = strings.TrimSpace(version)
+			break
+		}
+	}
+	if rules.Version == "" {
+		return nil, FLAG_RULES_VERSION_ERR
+	}
+
+	reader := csv.NewReader(bytes.NewReader(content))
+	reader.Comment = '#'
+	reader.FieldsPerRecord = len(FLAG_RULES_HEADER)
+
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	if !slices.Equal(header, FLAG_RULES_HEADER) {
+		return nil, fmt.Errorf("Wrong header %v, expected %v", header, FLAG_RULES_HEADER)
+	}
+
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		line, _ := reader.FieldPos(0)
+		rule, err := ruleFromRecord(record)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", line, err)
+		}
+
+		key := ruleKey{rule.Table, rule.Flag, rule.Value}
+		if _, ok := rules.rules[key]; ok {
+			return nil, fmt.Errorf("line %d: duplicated rule for %s flag %s (%s)", line, rule.Table, rule.Flag, rule.Value)
+		}
+		rules.rules[key] = rule
+	}
+
+	return rules, nil
+}
+
+func ruleFromRecord(record []string) (rule FlagRule, err error) {
+	rule.Table = record[0]
+	rule.Flag = record[1]
+
+	if rule.Flag != ANY_FLAG {
+		if _, err := strconv.ParseUint(rule.Flag, 10, 32); err != nil || len(rule.Flag) != 5 {
+			return rule, fmt.Errorf("flag must be 5 digits or %q, got %q", ANY_FLAG, rule.Flag)
+		}
+	}
+
+	if err := rule.Value.UnmarshalText([]byte(record[2])); err != nil {
+		return rule, err
+	}
+
+	if rule.Controlinfo, err = resolveFlag(record[3], CONTROLINFO_NAMES); err != nil {
+		return rule, fmt.Errorf("controlinfo: %w", err)
+	}
+
+	if record[4] != "" {
+		if rule.Useinfo, err = resolveFlag(record[4], USEINFO_NAMES); err != nil {
+			return rule, fmt.Errorf("useinfo: %w", err)
+		}
+	}
+	return rule, nil
+}
+
+// Looks up the name of a flag constant, or checks that the literal flag is valid
+func resolveFlag(s string, names map[string]string) (string, error) {
+	if flag, ok := names[s]; ok {
+		return flag, nil
+	}
+	if !flagRegex.MatchString(s) {
+		return "", fmt.Errorf("%q is neither a known constant nor a 16-digit flag", s)
+	}
+	return s, nil
+}
+
+// Sorted names of the tables with rules
+func (r *FlagRules) Tables() []string {
+	var tables []string
+	for key := range r.rules {
+		if !slices.Contains(tables, key.table) {
+			tables = append(tables, key.table)
+		}
+	}
+	slices.Sort(tables)
+	return tables
+}
+
+// Checks if the flag has its own rule. If the fallback is the only rule for the table
+// and value state, the flag is ignored on purpose, so it is also considered covered
+func (r *FlagRules) Covers(table, flag string, present bool) bool {
+	value := valueState(present)
+	if _, ok := r.rules[ruleKey{table, flag, value}]; ok {
+		return true
+	}
+
+	for key := range r.rules {
+		if key.table == table && key.value == value && key.flag != ANY_FLAG {
+			return false
+		}
+	}
+	return true
+}
+
+// Returns the rule matching the flag, falling back to the ANY_FLAG rule
+func (r *FlagRules) Lookup(table, flag string, present bool) (FlagRule, bool) {
+	value := valueState(present)
+	if rule, ok := r.rules[ruleKey{table, flag, value}]; ok {
+		return rule, true
+	}
+	rule, ok := r.rules[ruleKey{table, ANY_FLAG, value}]
+	return rule, ok
+}
+
+// Builds the ConvertFunction of the table from its rules
+func (r *FlagRules) ConvertFunc(table string) ConvertFunction {
+	return func(obs *KdvhObs, ts *TsInfo) (lard.DataObs, lard.TextObs, lard.Flag, error) {
+		var valPtr *float32
+
+		obs, err := ts.Transform.Obs(obs)
+		if err != nil {
+			return lard.DataObs{}, lard.TextObs{}, lard.Flag{}, err
+		}
+
+		val, err := ts.Transform.Value(obs.Data)
+		present := err == nil
+		if present {
+			valPtr = addr(val)
+		}
+
+		rule, ok := r.Lookup(table, obs.Flags, present)
+		if !ok {
+			return lard.DataObs{}, lard.TextObs{}, lard.Flag{}, fmt.Errorf("%w: flag %q (%s)", NO_FLAG_RULE_ERR, obs.Flags, valueState(present))
+		}
+
+		controlinfo := rule.Controlinfo
+		useinfoPtr := useinfo(obs)
+		if rule.Useinfo != "" {
+			useinfoPtr = addr(rule.Useinfo)
+		}
+
+		return lard.DataObs{
+				Id:      ts.Id,
+				Obstime: obs.Obstime,
+				Data:    valPtr,
+			},
+			lard.TextObs{
+				Id:      ts.Id,
+				Obstime: obs.Obstime,
+				Text:    &obs.Data,
+			},
+			lard.Flag{
+				Id:          ts.Id,
+				Obstime:     obs.Obstime,
+				Original:    valPtr,
+				Corrected:   valPtr,
+				Controlinfo: &controlinfo,
+				Useinfo:     useinfoPtr,
+			}, nil
+	}
+}
+
+// Converts the observations of the tables listed in the rules file with the rules.
+// The tables that already use flag rules must be listed in the file
+func (k *KDVH) SetFlagRules(rules *FlagRules) error {
+	tables := rules.Tables()
+	for _, name := range tables {
+		if _, ok := k.Tables[name]; !ok {
+			return fmt.Errorf("Flag rules for unknown table %s", name)
+		}
+	}
+
+	for name, table := range k.Tables {
+		if table.FlagRules != nil && !slices.Contains(tables, name) {
+			return fmt.Errorf("Missing flag rules for table %s", name)
+		}
+	}
+
+	for _, name := range tables {
+		k.Tables[name].SetFlagRules(rules)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestDefaultFlagRules(t *testing.T) {
+	type testCase struct {
+		table    string
+		obs      KdvhObs
+		expected string
+		covered  bool
+	}
+
+	cases := []testCase{
+		{"T_EDATA", KdvhObs{Data: "", Flags: "70381"}, VALUE_REMOVED_BY_QC, true},
+		{"T_EDATA", KdvhObs{Data: "", Flags: "12345"}, VALUE_MISSING, false},
+		{"T_EDATA", KdvhObs{Data: "1.5", Flags: "70381"}, VALUE_PASSED_QC, true},
+		{"T_PDATA", KdvhObs{Data: "", Flags: "20389"}, VALUE_REMOVED_BY_QC, true},
+		{"T_PDATA", KdvhObs{Data: "", Flags: "40929"}, VALUE_MISSING, true},
+		{"T_PDATA", KdvhObs{Data: "0.3", Flags: "40929"}, INTERPOLATION_ADDED_MANUALLY, true},
+		{"T_PDATA", KdvhObs{Data: "0.3", Flags: "99319"}, VALUE_CORRECTED_AUTOMATICALLY, true},
+		{"T_NDATA", KdvhObs{Data: "2", Flags: "43325"}, VALUE_MANUALLY_ASSIGNED, true},
+		{"T_NDATA", KdvhObs{Data: "-", Flags: "70389"}, VALUE_REMOVED_BY_QC, true},
+		{"T_NDATA", KdvhObs{Data: "2", Flags: "11111"}, VALUE_PASSED_QC, false},
+	}
+
+	for _, c := range cases {
+		t.Log("Testing flag:", c.table, c.obs.Flags, c.obs.Data)
+
+		_, _, flag, err := DEFAULT_FLAG_RULES.ConvertFunc(c.table)(&c.obs, &TsInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *flag.Controlinfo != c.expected {
+			t.Errorf("Got controlinfo %s, wanted %s", *flag.Controlinfo, c.expected)
+		}
+		if expected := c.obs.Flags + DELAY_DEFAULT; *flag.Useinfo != expected {
+			t.Errorf("Got useinfo %s, wanted %s", *flag.Useinfo, expected)
+		}
+
+		present := flag.Original != nil
+		if covered := DEFAULT_FLAG_RULES.Covers(c.table, c.obs.Flags, present); covered != c.covered {
+			t.Errorf("Got covered %v, wanted %v", covered, c.covered)
+		}
+	}
+
+	if _, _, _, err := DEFAULT_FLAG_RULES.ConvertFunc("T_ADATA")(&KdvhObs{Flags: "70000"}, &TsInfo{}); !errors.Is(err, NO_FLAG_RULE_ERR) {
+		t.Errorf("Got %v, wanted %v", err, NO_FLAG_RULE_ERR)
+	}
+}
+
+func TestParseFlagRules(t *testing.T) {
+	const header = "# version: 2\ntable,flag,value,controlinfo,useinfo\n"
+
+	type testCase struct {
+		name    string
+		content string
+		ok      bool
+	}
+
+	cases := []testCase{
+		{"valid", header + "T_EDATA,70381,missing,VALUE_REMOVED_BY_QC,COMPLETED_HQC\nT_EDATA,*,present,0000000000000001,\n", true},
+		{"missing version", "table,flag,value,controlinfo,useinfo\n", false},
+		{"wrong header", "# version: 2\ntable,flag,controlinfo\n", false},
+		{"unknown constant", header + "T_EDATA,70381,missing,VALUE_GONE,\n", false},
+		{"short literal", header + "T_EDATA,70381,missing,0000,\n", false},
+		{"bad flag", header + "T_EDATA,7038,missing,VALUE_MISSING,\n", false},
+		{"bad value", header + "T_EDATA,70381,empty,VALUE_MISSING,\n", false},
+		{"duplicated", header + "T_EDATA,70381,missing,VALUE_MISSING,\nT_EDATA,70381,missing,VALUE_REMOVED_BY_QC,\n", false},
+	}
+
+	for _, c := range cases {
+		t.Log("Testing rules:", c.name)
+
+		rules, err := ParseFlagRules([]byte(c.content))
+		if (err == nil) != c.ok {
+			t.Errorf("Got error %v, wanted ok %v", err, c.ok)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+
+		if rules.Version != "2" {
+			t.Errorf("Got version %q, wanted %q", rules.Version, "2")
+		}
+
+		_, _, flag, err := rules.ConvertFunc("T_EDATA")(&KdvhObs{Flags: "70381"}, &TsInfo{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if *flag.Useinfo != COMPLETED_HQC {
+			t.Errorf("Got useinfo %s, wanted %s", *flag.Useinfo, COMPLETED_HQC)
+		}
+	}
+}
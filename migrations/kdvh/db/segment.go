@@ -0,0 +1,171 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"migrate/utils"
+)
+
+// Dumped file of a series. A series can be split in multiple files:
+//   - tables partitioned by time window store each window in its own directory, e.g. `2001/TA.csv`
+//   - incremental dumps write the rows newer than the last dump to segment files
+//     next to the first dumped file of the series, e.g. `TA.csv`, `TA.1.csv`, `TA.2.csv`.
+//     Segment 0 is the first dumped file.
+type Segment struct {
+	Element  string
+	Window   string // Name of the window directory, empty for tables that are not partitioned
+	Number   int
+	Filename string
+}
+
+// Name of the segment without extension, also used to identify it in reports and checkpoints
+func (s Segment) Name() string {
+	name := s.Element
+	if s.Number > 0 {
+		name = fmt.Sprintf("%s.%d", s.Element, s.Number)
+	}
+	if s.Window != "" {
+		name = s.Window + "/" + name
+	}
+	return name
+}
+
+// Orders segments chronologically, i.e. by window and then by segment number
+func CompareSegments(a, b Segment) int {
+	if c := strings.Compare(a.Window, b.Window); c != 0 {
+		return c
+	}
+	return a.Number - b.Number
+}
+
+// Lists the dumped files in the directory of a station, including the ones in time window
+// directories. Element codes are converted to upper case, and files that are not dumped
+// series are ignored.
+func ListSegments(dir string) ([]Segment, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var segments []Segment
+	for _, file := range files {
+		if file.IsDir() && IsWindowDir(file.Name()) {
+			windowSegments, err := ListSegments(filepath.Join(dir, file.Name()))
+			if err != nil {
+				return nil, err
+			}
+			for _, segment := range windowSegments {
+				if segment.Window == "" {
+					segment.Window = file.Name()
+					segments = append(segments, segment)
+				}
+			}
+			continue
+		}
+
+		name, ok := utils.TrimDumpExtension(file.Name())
+		if file.IsDir() || !ok {
+			continue
+		}
+
+		if element, number, ok := ParseSegment(strings.ToUpper(name)); ok {
+			segments = append(segments, Segment{element, "", number, filepath.Join(dir, file.Name())})
+		}
+	}
+	return segments, nil
+}
+
+// Parses the name of a dumped file (without the CSV extension) into element code and segment number
+func ParseSegment(name string) (string, int, bool) {
+	element, number, found := strings.Cut(name, ".")
+	if !found {
+		return element, 0, true
+	}
+
+	n, err := strconv.Atoi(number)
+	if err != nil || n < 1 {
+		return "", 0, false
+	}
+	return element, n, true
+}
+
+// Lists the dumped segments of the element in the directory, sorted by segment number
+func FindSegments(dir, element string) ([]Segment, error) {
+	files, err := os.ReadDir(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	var segments []Segment
+	for _, file := range files {
+		name, ok := utils.TrimDumpExtension(file.Name())
+		if file.IsDir() || !ok {
+			continue
+		}
+
+		if code, number, ok := ParseSegment(name); ok && code == element {
+			segments = append(segments, Segment{code, "", number, filepath.Join(dir, file.Name())})
+		}
+	}
+
+	slices.SortFunc(segments, func(a, b Segment) int { return a.Number - b.Number })
+	return segments, nil
+}
+
+// Returns the time of the last observation in a dumped file
+func LastObstime(filename string) (time.Time, error) {
+	if utils.IsParquet(filename) {
+		return lastParquetObstime(filename)
+	}
+
+	file, err := utils.OpenDecompressed(filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer file.Close()
+
+	var last string
+	reader := NewDumpReader(file)
+	for reader.Next() {
+		last = reader.Line()
+	}
+	if err := reader.Err(); err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", filename, err)
+	}
+	if last == "" {
+		return time.Time{}, fmt.Errorf("%s: no observations found", filename)
+	}
+
+	// The separator of older dumps might differ, but the time is always the first field
+	obstime, _, _ := strings.Cut(last, ",")
+	obstime, _, _ = strings.Cut(obstime, ";")
+	return time.Parse(TIMEFORMAT, obstime)
+}
+
+func lastParquetObstime(filename string) (time.Time, error) {
+	reader, err := utils.OpenParquet[parquetRecord](filename)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer reader.Close()
+
+	var last *time.Time
+	err = reader.Each(func(record *parquetRecord) error {
+		last = &record.Time
+		return nil
+	})
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s: %w", filename, err)
+	}
+	if last == nil {
+		return time.Time{}, fmt.Errorf("%s: no observations found", filename)
+	}
+	return *last, nil
+}
@@ -0,0 +1,134 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNextSegment(t *testing.T) {
+	dir := t.TempDir()
+	opts := DumpOptions{Incremental: true}
+
+	filename, after, err := opts.nextSegment(dir, "TA")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filename != filepath.Join(dir, "TA.csv") || after != nil {
+		t.Errorf("Got (%v, %v), wanted first dump of the element", filename, after)
+	}
+
+	files := map[string]string{
+		"TA.csv":   "2\n2001-07-01_09:00:00;12.9;70000\n2001-07-01_10:00:00;13.1;70000\n",
+		"TA.1.csv": "2001-07-01_11:00:00,13.4,70000\n# rows: 1\n",
+		"TAX.csv":  "2001-07-01_12:00:00,15.0,70000\n# rows: 1\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	filename, after, err = opts.nextSegment(dir, "TA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := time.Date(2001, 7, 1, 11, 0, 0, 0, time.UTC)
+	if filename != filepath.Join(dir, "TA.2.csv") {
+		t.Errorf("Got %v, wanted %v", filename, filepath.Join(dir, "TA.2.csv"))
+	}
+	if after == nil || !after.Equal(expected) {
+		t.Errorf("Got %v, wanted %v", after, expected)
+	}
+}
+
+func TestParseSegment(t *testing.T) {
+	type testCase struct {
+		input   string
+		element string
+		number  int
+		ok      bool
+	}
+
+	cases := []testCase{
+		{"TA", "TA", 0, true},
+		{"TA.3", "TA", 3, true},
+		{"TA.0", "", 0, false},
+		{"TA.x", "", 0, false},
+	}
+
+	for _, c := range cases {
+		t.Log("Testing name:", c.input)
+
+		element, number, ok := ParseSegment(c.input)
+		if element != c.element || number != c.number || ok != c.ok {
+			t.Errorf("Got (%v, %v, %v), wanted (%v, %v, %v)", element, number, ok, c.element, c.number, c.ok)
+		}
+	}
+}
+
+func TestFirstWindow(t *testing.T) {
+	path := t.TempDir()
+	files := map[string]string{
+		"2001/TA.csv":   "2001-07-01_11:00:00,13.4,70000\n# rows: 1\n",
+		"2002/TA.csv":   "2002-03-01_11:00:00,5.2,70000\n# rows: 1\n",
+		"2002/TA.1.csv": "2002-05-01_10:00:00,9.8,70000\n# rows: 1\n",
+		"2003/TX.csv":   "2003-01-01_06:00:00,1.0,70000\n# rows: 1\n",
+	}
+	for name, content := range files {
+		filename := filepath.Join(path, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	begin := time.Date(2001, 3, 1, 0, 0, 0, 0, time.UTC)
+
+	type testCase struct {
+		name        string
+		element     string
+		incremental bool
+		first       time.Time
+		filename    string     // Target of the first window
+		after       *time.Time // Last row dumped in the first window
+	}
+
+	after := time.Date(2002, 5, 1, 10, 0, 0, 0, time.UTC)
+	cases := []testCase{
+		{"full dump", "TA", false, time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC), "", nil},
+		{"incremental, resumes from the last window", "TA", true, time.Date(2002, 1, 1, 0, 0, 0, 0, time.UTC), "2002/TA.2.csv", &after},
+		{"incremental, never dumped", "FF", true, time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC), "2001/FF.csv", nil},
+	}
+
+	for _, c := range cases {
+		t.Log("Testing", c.name)
+
+		opts := DumpOptions{Incremental: c.incremental}
+		first, err := opts.firstWindow(path, c.element, YEAR, begin)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !first.Equal(c.first) {
+			t.Errorf("Got %v, wanted %v", first, c.first)
+		}
+		if !c.incremental {
+			continue
+		}
+
+		filename, got, err := opts.target(filepath.Join(path, YEAR.Dirname(first)), c.element)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if filename != filepath.Join(path, c.filename) {
+			t.Errorf("Got %v, wanted %v", filename, c.filename)
+		}
+		if (got == nil) != (c.after == nil) || got != nil && !got.Equal(*c.after) {
+			t.Errorf("Got %v, wanted %v", got, c.after)
+		}
+	}
+}
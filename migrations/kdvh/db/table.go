@@ -1,9 +1,17 @@
 package db
 
 import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"time"
+
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"migrate/lard"
+	"migrate/manifest"
+	"migrate/utils"
 )
 
 // In KDVH for each table name we usually have three separate tables:
@@ -23,11 +31,15 @@ import (
 
 // This struct contains basic metadata for a KDVH table
 type Table struct {
-	TableName     string // Name of the DATA table
-	FlagTableName string // Name of the FLAG table
-	ElemTableName string // Name of the ELEM table
-	Path          string // Directory name of where the dumped table is stored
-	importUntil   int    // Import data only until the year specified by this field. Table import will be skipped, if `SetImportYear` is not called.
+	TableName     string     // Name of the DATA table
+	FlagTableName string     // Name of the FLAG table
+	ElemTableName string     // Name of the ELEM table
+	Path          string     // Directory name of where the dumped table is stored
+	ConnEnvVar    string     // Env variable with the connection string of the database where the table is stored
+	Quarantined   bool       // Set by `Quarantine`, the table only contains the columns that are not imported by default
+	importUntil   int        // Import data only until the year specified by this field. Table import will be skipped, if `SetImportYear` is not called.
+	Window        Window     // Time window used to partition the dumped series, see `SetWindow`
+	FlagRules     *FlagRules // Set if Convert is built from the flag rules, see `SetFlagRules`
 	DumpFn        DumpFunction
 	Convert       ConvertFunction
 }
@@ -39,19 +51,86 @@ func NewTable(data, flag, elem string) *Table {
 		FlagTableName: flag,
 		ElemTableName: elem,
 		// NOTE: '_combined' kept for backward compatibility with original scripts
-		Path:    data + "_combined",
-		DumpFn:  dumpDataAndFlags,
-		Convert: convert,
+		Path:       data + "_combined",
+		ConnEnvVar: KDVH_ENV_VAR,
+		DumpFn:     dumpDataAndFlags,
+		Convert:    convert,
 	}
 }
 
-// Function used to dump the KDVH table, see below
-type DumpFunction func(path string, args dumpArgs, logStr string, overwrite bool, pool *pgxpool.Pool) error
+// Function used to dump the KDVH table, see below. Returns the number of dumped rows
+type DumpFunction func(ctx context.Context, path string, args dumpArgs, logStr string, opts DumpOptions, pool *pgxpool.Pool) (int64, error)
+
+// Options shared by all the dumped series
+type DumpOptions struct {
+	Overwrite   bool
+	Incremental bool // Only dump the rows newer than the ones already on disk, see Segment
+	Format      utils.Format
+	Compression utils.Compression
+	Manifest    *manifest.Manifest // Optional, the dumped files are added to it
+}
+
+// Adds the dumped file to the manifest
+func (opts DumpOptions) record(filename string, args dumpArgs, from, to time.Time, rows int64) error {
+	if opts.Manifest == nil {
+		return nil
+	}
+
+	stnr, _ := strconv.ParseInt(args.station, 10, 32)
+	return opts.Manifest.Add(filename, manifest.Entry{
+		Station: int32(stnr),
+		Series:  args.element,
+		From:    &from,
+		To:      &to,
+		Rows:    rows,
+	})
+}
+
+// Name of the dumped file of the element in the given directory
+func (opts DumpOptions) filename(dir, element string) string {
+	return filepath.Join(dir, element+opts.Format.Extension(opts.Compression))
+}
+
+// Returns the name of the next segment of the element and the time of the last observation
+// already dumped, which is nil if the element was never dumped
+func (opts DumpOptions) nextSegment(dir, element string) (string, *time.Time, error) {
+	segments, err := FindSegments(dir, element)
+	if err != nil || len(segments) == 0 {
+		return opts.filename(dir, element), nil, err
+	}
+
+	var last *time.Time
+	for _, segment := range segments {
+		obstime, err := opts.lastObstime(segment.Filename)
+		if err != nil {
+			return "", nil, err
+		}
+		if last == nil || obstime.After(*last) {
+			last = &obstime
+		}
+	}
+
+	number := segments[len(segments)-1].Number + 1
+	filename := filepath.Join(dir, fmt.Sprintf("%s.%d%s", element, number, opts.Format.Extension(opts.Compression)))
+	return filename, last, nil
+}
+
+// Reads the time of the last observation from the manifest, falling back to the file for older dumps
+func (opts DumpOptions) lastObstime(filename string) (time.Time, error) {
+	if opts.Manifest != nil {
+		if entry, ok := opts.Manifest.Lookup(filename); ok && entry.To != nil {
+			return *entry.To, nil
+		}
+	}
+	return LastObstime(filename)
+}
+
 type dumpArgs struct {
 	element   string
 	station   string
 	dataTable string
 	flagTable string
+	window    Window
 }
 
 // The following ConvertFunctions try to recover the original pair of `controlinfo`
@@ -61,8 +140,8 @@ type dumpArgs struct {
 // It returns three structs for each of the lard tables we are inserting into
 type ConvertFunction func(*KdvhObs, *TsInfo) (lard.DataObs, lard.TextObs, lard.Flag, error)
 
-func (t *Table) Dump(path, element, station, logStr string, overwrite bool, pool *pgxpool.Pool) error {
-	return t.DumpFn(path, dumpArgs{element, station, t.TableName, t.FlagTableName}, logStr, overwrite, pool)
+func (t *Table) Dump(ctx context.Context, path, element, station, logStr string, opts DumpOptions, pool *pgxpool.Pool) (int64, error) {
+	return t.DumpFn(ctx, path, dumpArgs{element, station, t.TableName, t.FlagTableName, t.Window}, logStr, opts, pool)
 }
 
 func (t *Table) SetDumpFunc(fn DumpFunction) *Table {
@@ -70,11 +149,45 @@ func (t *Table) SetDumpFunc(fn DumpFunction) *Table {
 	return t
 }
 
+// Partitions the dumped series in time windows of the given size
+func (t *Table) SetWindow(window Window) *Table {
+	t.Window = window
+	t.DumpFn = dumpByWindow
+	return t
+}
+
 func (t *Table) SetConvertFunc(fn ConvertFunction) *Table {
 	t.Convert = fn
 	return t
 }
 
+// Converts the KDVH flags with the rules of the table, see `flag_rules.csv`
+func (t *Table) SetFlagRules(rules *FlagRules) *Table {
+	t.FlagRules = rules
+	t.Convert = rules.ConvertFunc(t.TableName)
+	return t
+}
+
+// Directory, relative to the dump directory, where the quarantined columns are dumped
+const QUARANTINE_DIR = "_quarantine"
+
+// Returns a copy of the table used to dump the columns that are never imported (e.g. the 'kopi' columns).
+// These columns are dumped without flags and time windows, to the same layout under QUARANTINE_DIR
+func (t *Table) Quarantine() *Table {
+	quarantine := *t
+	quarantine.Path = filepath.Join(QUARANTINE_DIR, t.Path)
+	quarantine.Quarantined = true
+	quarantine.DumpFn = dumpQuarantined
+	quarantine.Window = NO_WINDOW
+	return &quarantine
+}
+
+// Specify the env variable with the connection string of the database, if the table is not in the KDVH proxy
+func (t *Table) SetSource(envVar string) *Table {
+	t.ConnEnvVar = envVar
+	return t
+}
+
 // Specify the year until data should be imported
 func (t *Table) SetImportYear(year int) *Table {
 	if year > 0 {
@@ -83,6 +196,11 @@ func (t *Table) SetImportYear(year int) *Table {
 	return t
 }
 
+// Year until data is imported, zero if the table is not set for import
+func (t *Table) ImportYear() int {
+	return t.importUntil
+}
+
 // Checks if the table is set for import
 func (t *Table) ShouldImport() bool {
 	return t.importUntil > 0
@@ -0,0 +1,88 @@
+package db
+
+import (
+	"errors"
+	"strconv"
+
+	"github.com/rickb777/period"
+)
+
+// Transformation applied by the ConvertFunctions to the observations of a (table, element) series,
+// e.g. when KDVH stores the element in a different unit or at a different obstime than Kvalobs.
+// The raw value is remapped first, then it is parsed, scaled and offset.
+type Transform struct {
+	Remap  map[string]string // Replaces raw values before they are parsed
+	Scale  *float64          // Factor the parsed value is multiplied by, nil if the value is not scaled
+	Offset float64           // Added to the parsed value after scaling
+	Shift  period.Period     // Added to the obstime
+}
+
+type TransformKey struct {
+	Table   string
+	Element string
+}
+
+// Scale factors of unit conversions
+const HOURS_TO_MINUTES float64 = 60
+
+// Registry of the transformations applied to specific series.
+// Elements listed in INVALID_ELEMENTS (see `kdvh/import`) are imported once they have an entry here.
+// The obstimes of the products are also corrected by `product_offsets.csv`, after the transformation
+var TRANSFORMS = map[TransformKey]*Transform{
+	// OT_24 in KDVH has been treated differently than in Kvalobs:
+	// it is stored in hours, and it needs a custom offset (fromtime_offset -PT6H, timespan P1D)
+	{"T_VDATA", "OT_24"}: {Scale: addr(HOURS_TO_MINUTES), Shift: period.MustParse("PT18H")},
+
+	// Sunshine duration is stored in hours, while Kvalobs uses minutes
+	{"T_DIURNAL", "OT"}: {Scale: addr(HOURS_TO_MINUTES)},
+	{"T_MONTH", "OT"}:   {Scale: addr(HOURS_TO_MINUTES)},
+	{"T_MONTH", "OTN"}:  {Scale: addr(HOURS_TO_MINUTES)},
+	{"T_MONTH", "OTX"}:  {Scale: addr(HOURS_TO_MINUTES)},
+
+	// Wind direction of the 06, 12 and 18 UTC observations, stored at the date of the day
+	{"T_DIURNAL", "DD06"}: {Shift: period.MustParse("PT6H")},
+	{"T_DIURNAL", "DD12"}: {Shift: period.MustParse("PT12H")},
+	{"T_DIURNAL", "DD18"}: {Shift: period.MustParse("PT18H")},
+}
+
+// Returns the transformation of the series, nil if there is none
+func LookupTransform(table, element string) *Transform {
+	return TRANSFORMS[TransformKey{table, element}]
+}
+
+var TRANSFORM_SHIFT_ERR error = errors.New("could not shift obstime")
+
+// Returns a copy of the observation with remapped value and shifted obstime.
+// A nil Transform returns the observation itself
+func (t *Transform) Obs(obs *KdvhObs) (*KdvhObs, error) {
+	if t == nil {
+		return obs, nil
+	}
+
+	transformed := *obs
+	if value, ok := t.Remap[obs.Data]; ok {
+		transformed.Data = value
+	}
+
+	if !t.Shift.IsZero() {
+		obstime, ok := t.Shift.AddTo(obs.Obstime)
+		if !ok {
+			return nil, TRANSFORM_SHIFT_ERR
+		}
+		transformed.Obstime = obstime
+	}
+	return &transformed, nil
+}
+
+// Parses the value, and applies scale factor and offset
+func (t *Transform) Value(data string) (float32, error) {
+	val, err := strconv.ParseFloat(data, 32)
+	if err != nil || t == nil {
+		return float32(val), err
+	}
+
+	if t.Scale != nil {
+		val *= *t.Scale
+	}
+	return float32(val + t.Offset), nil
+}
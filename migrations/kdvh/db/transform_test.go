@@ -0,0 +1,56 @@
+package db
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTransform(t *testing.T) {
+	obstime := time.Date(2001, 7, 1, 6, 0, 0, 0, time.UTC)
+	remap := &Transform{Remap: map[string]string{"-1": "0"}, Scale: addr(0.1), Offset: 1}
+
+	type testCase struct {
+		name    string
+		convert ConvertFunction
+		ts      *TsInfo
+		data    string
+		value   *float32
+		obstime time.Time
+		useinfo string
+	}
+
+	cases := []testCase{
+		{"OT_24", convertVdata, &TsInfo{Element: "OT_24", Transform: LookupTransform("T_VDATA", "OT_24")}, "1.5", addr[float32](90), obstime.Add(18 * time.Hour), COMPLETED_HQC},
+		{"OT_24 missing", convertVdata, &TsInfo{Element: "OT_24", Transform: LookupTransform("T_VDATA", "OT_24")}, "", nil, obstime.Add(18 * time.Hour), COMPLETED_HQC},
+		{"T_DIURNAL OT", convert, &TsInfo{Transform: LookupTransform("T_DIURNAL", "OT")}, "10.5", addr[float32](630), obstime, "70000" + DELAY_DEFAULT},
+		{"T_MONTH OTX", convert, &TsInfo{Transform: LookupTransform("T_MONTH", "OTX")}, "14", addr[float32](840), obstime, "70000" + DELAY_DEFAULT},
+		{"T_DIURNAL DD18", convert, &TsInfo{Transform: LookupTransform("T_DIURNAL", "DD18")}, "270", addr[float32](270), obstime.Add(18 * time.Hour), "70000" + DELAY_DEFAULT},
+		{"no transform", convertVdata, &TsInfo{Element: "TA"}, "1.5", addr[float32](1.5), obstime, COMPLETED_HQC},
+		{"remapped", convert, &TsInfo{Transform: remap}, "-1", addr[float32](1), obstime, "70000" + DELAY_DEFAULT},
+		{"scaled", convert, &TsInfo{Transform: remap}, "20", addr[float32](3), obstime, "70000" + DELAY_DEFAULT},
+		{"scaled to zero", convert, &TsInfo{Transform: &Transform{Scale: addr(0.0)}}, "20", addr[float32](0), obstime, "70000" + DELAY_DEFAULT},
+	}
+
+	for _, c := range cases {
+		t.Log("Testing transform:", c.name)
+
+		obs := KdvhObs{Obstime: obstime, Data: c.data, Flags: "70000"}
+		data, _, flag, err := c.convert(&obs, c.ts)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if (data.Data == nil) != (c.value == nil) || data.Data != nil && *data.Data != *c.value {
+			t.Errorf("Got value %v, wanted %v", data.Data, c.value)
+		}
+		if !data.Obstime.Equal(c.obstime) || !flag.Obstime.Equal(c.obstime) {
+			t.Errorf("Got obstime %v, wanted %v", data.Obstime, c.obstime)
+		}
+		if *flag.Useinfo != c.useinfo {
+			t.Errorf("Got useinfo %s, wanted %s", *flag.Useinfo, c.useinfo)
+		}
+		if !obs.Obstime.Equal(obstime) || obs.Data != c.data {
+			t.Errorf("The original observation was modified: %+v", obs)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package db
+
+import (
+	"time"
+)
+
+// Size of the time windows used to partition the dumps of large tables,
+// where each window is stored in its own directory, i.e. `station/<window>/element.csv`
+type Window string
+
+const (
+	NO_WINDOW Window = ""
+	YEAR      Window = "year"
+	MONTH     Window = "month"
+	DAY       Window = "day"
+)
+
+// Layouts of the window directory names, from the most to the least specific
+var windowLayouts = []string{"2006-01-02", "2006-01", "2006"}
+
+// Start of the window containing the timestamp
+func (w Window) Start(t time.Time) time.Time {
+	switch w {
+	case YEAR:
+		return time.Date(t.Year(), 1, 1, 0, 0, 0, 0, t.Location())
+	case MONTH:
+		return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, t.Location())
+	}
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// Start of the window following the one that starts at `start`
+func (w Window) Next(start time.Time) time.Time {
+	switch w {
+	case YEAR:
+		return start.AddDate(1, 0, 0)
+	case MONTH:
+		return start.AddDate(0, 1, 0)
+	}
+	return start.AddDate(0, 0, 1)
+}
+
+// Name of the directory of the window that starts at `start`
+func (w Window) Dirname(start time.Time) string {
+	switch w {
+	case YEAR:
+		return start.Format("2006")
+	case MONTH:
+		return start.Format("2006-01")
+	}
+	return start.Format("2006-01-02")
+}
+
+// Returns true if the directory name is a window directory
+func IsWindowDir(name string) bool {
+	for _, layout := range windowLayouts {
+		if _, err := time.Parse(layout, name); err == nil {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,67 @@
+package db
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestWindows(t *testing.T) {
+	begin := time.Date(2001, 11, 15, 10, 0, 0, 0, time.UTC)
+	end := time.Date(2002, 2, 1, 0, 0, 0, 0, time.UTC)
+
+	type testCase struct {
+		window   Window
+		expected []string
+	}
+
+	cases := []testCase{
+		{YEAR, []string{"2001", "2002"}},
+		{MONTH, []string{"2001-11", "2001-12", "2002-01", "2002-02"}},
+	}
+
+	for _, c := range cases {
+		t.Log("Testing window:", c.window)
+
+		var dirs []string
+		for start := c.window.Start(begin); !start.After(end); start = c.window.Next(start) {
+			dirs = append(dirs, c.window.Dirname(start))
+		}
+
+		if !slices.Equal(dirs, c.expected) {
+			t.Errorf("Got %v, wanted %v", dirs, c.expected)
+		}
+	}
+}
+
+func TestListSegments(t *testing.T) {
+	dir := t.TempDir()
+	files := []string{"2002/TA.csv", "2001/TA.csv", "2001/TA.1.csv.gz", "TAX.csv", "notes.txt"}
+	for _, file := range files {
+		filename := filepath.Join(dir, file)
+		if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	segments, err := ListSegments(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.SortFunc(segments, CompareSegments)
+
+	var names []string
+	for _, segment := range segments {
+		names = append(names, segment.Name())
+	}
+
+	expected := []string{"TAX", "2001/TA", "2001/TA.1", "2002/TA"}
+	if !slices.Equal(names, expected) {
+		t.Errorf("Got %v, wanted %v", names, expected)
+	}
+}
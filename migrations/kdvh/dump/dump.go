@@ -2,10 +2,13 @@ package dump
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"sync"
 
@@ -13,38 +16,89 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"migrate/kdvh/db"
+	"migrate/manifest"
+	"migrate/metrics"
+	"migrate/report"
 	"migrate/utils"
 )
 
+// Columns of the KDVH tables that are not element codes
+var KEY_COLUMNS = []string{"dato", "stnr"}
+
+// Columns that are only dumped with `--include-quarantined`, together with all the columns that contain 'kopi'
+var QUARANTINED_COLUMNS = []string{"typeid", "season", "xxx"}
+
 // List of columns that we do not need to select when extracting the element codes from a KDVH table
-var INVALID_COLUMNS = []string{"dato", "stnr", "typeid", "season", "xxx"}
+var INVALID_COLUMNS = slices.Concat(KEY_COLUMNS, QUARANTINED_COLUMNS)
 
-func DumpTable(table *db.Table, pool *pgxpool.Pool, config *Config) {
+// Function used to list the columns of the table that should be dumped
+type fetchFunction func(ctx context.Context, table *db.Table, pool *pgxpool.Pool) ([]string, error)
+
+func DumpTable(ctx context.Context, table *db.Table, pool *pgxpool.Pool, config *Config, tableReport *report.Table) {
 	fmt.Printf("Dumping %s...\n", table.TableName)
+	dumpTable(ctx, table, fetchElements, pool, config, tableReport)
+}
+
+func dumpTable(ctx context.Context, table *db.Table, fetch fetchFunction, pool *pgxpool.Pool, config *Config, tableReport *report.Table) {
 	defer fmt.Println(strings.Repeat("- ", 40))
+	defer tableReport.Finish()
+
+	tableMetrics := metrics.Table{Source: "kdvh", Procedure: "dump", Name: tableReport.Name}
+	tableMetrics.Start()
+	defer tableMetrics.Finish()
+
+	tablePath := filepath.Join(config.Path, table.Path)
+	if err := os.MkdirAll(tablePath, os.ModePerm); err != nil {
+		slog.Error(err.Error())
+		tableReport.Fail(err)
+		return
+	}
 
-	if err := os.MkdirAll(filepath.Join(config.Path, table.Path), os.ModePerm); err != nil {
+	dumpManifest, err := manifest.Open(tablePath, table.TableName)
+	if err != nil {
 		slog.Error(err.Error())
+		tableReport.Fail(err)
 		return
 	}
+	defer dumpManifest.Close()
 
-	elements, err := getElements(table, pool, config)
+	// Saved so the timespans can be read during import without connecting to KDVH
+	if err := table.DumpElem(ctx, tablePath, pool); err != nil {
+		slog.Warn(fmt.Sprintf("%s: could not save ELEM metadata - %s", table.TableName, err))
+	}
+
+	elements, err := getElements(ctx, table, fetch, pool, config)
 	if err != nil {
+		tableReport.Fail(err)
 		return
 	}
 
-	stations, err := getStations(table, pool, config)
+	stations, err := getStations(ctx, table, pool, config)
 	if err != nil {
+		tableReport.Fail(err)
 		return
 	}
 
 	// Used to limit connections to the database
 	semaphore := make(chan struct{}, config.MaxConn)
+	opts := db.DumpOptions{
+		Overwrite:   config.Overwrite,
+		Incremental: config.Incremental,
+		Format:      config.Format,
+		Compression: config.Compress,
+		Manifest:    dumpManifest,
+	}
 
 	for _, station := range stations {
+		if ctx.Err() != nil {
+			slog.Warn(fmt.Sprintf("%s: dump interrupted", table.TableName))
+			return
+		}
+
 		path := filepath.Join(config.Path, table.Path, station)
 		if err := os.MkdirAll(path, os.ModePerm); err != nil {
 			slog.Error(err.Error())
+			tableReport.Fail(err)
 			return
 		}
 
@@ -63,15 +117,42 @@ func DumpTable(table *db.Table, pool *pgxpool.Pool, config *Config) {
 					wg.Done()
 				}()
 
+				// Release semaphore
+				defer func() { <-semaphore }()
+
+				// Series that did not start yet are left for the next run
+				if ctx.Err() != nil {
+					return
+				}
+
 				logStr := fmt.Sprintf("%s - %s - %s: ", table.TableName, station, element)
+				stnr, _ := strconv.ParseInt(station, 10, 32)
+				stats := tableReport.NewSeries(int32(stnr), element)
+				tableMetrics.StartSeries(int32(stnr))
+
+				count, err := table.Dump(ctx, path, element, station, logStr, opts, pool)
+				switch {
+				case errors.Is(err, db.EMPTY_QUERY_ERR) && config.Incremental:
+					stats.SkipSeries("no new rows")
+				case errors.Is(err, db.EMPTY_QUERY_ERR):
+					stats.SkipSeries("empty")
+				case errors.Is(err, db.FILE_EXISTS_ERR):
+					stats.SkipSeries("already dumped")
+				default:
+					stats.RowsRead = count
+					stats.RowsInserted = count
+					stats.Finish(err)
+				}
+
+				if stats.Skipped != "" {
+					tableMetrics.SeriesSkipped(0)
+				} else {
+					tableMetrics.SeriesDone(count, count, err)
+				}
 
-				err := table.Dump(path, element, station, logStr, config.Overwrite, pool)
 				if err == nil {
 					slog.Info(logStr + "dumped successfully")
 				}
-
-				// Release semaphore
-				<-semaphore
 			}()
 		}
 		wg.Wait()
@@ -79,8 +160,8 @@ func DumpTable(table *db.Table, pool *pgxpool.Pool, config *Config) {
 }
 
 // Fetches elements and filters them based on user input
-func getElements(table *db.Table, pool *pgxpool.Pool, config *Config) ([]string, error) {
-	elements, err := fetchElements(table, pool)
+func getElements(ctx context.Context, table *db.Table, fetch fetchFunction, pool *pgxpool.Pool, config *Config) ([]string, error) {
+	elements, err := fetch(ctx, table, pool)
 	if err != nil {
 		return nil, err
 	}
@@ -95,9 +176,9 @@ func getElements(table *db.Table, pool *pgxpool.Pool, config *Config) ([]string,
 }
 
 // Fetch column names for a given table
-// We skip the columns defined in INVALID_COLUMNS and all columns that contain the 'kopi' string
-// TODO: should we dump these invalid/kopi elements even if we are not importing them?
-func fetchElements(table *db.Table, pool *pgxpool.Pool) (elements []string, err error) {
+// We skip the columns defined in INVALID_COLUMNS and all columns that contain the 'kopi' string,
+// they can be dumped separately with `--include-quarantined`, see `DumpQuarantine`
+func fetchElements(ctx context.Context, table *db.Table, pool *pgxpool.Pool) (elements []string, err error) {
 	slog.Info(fmt.Sprintf("Fetching elements for %s...", table.TableName))
 
 	// NOTE: T_HOMOGEN_MONTH is a special case, refer to `dumpHomogenMonth` in
@@ -107,7 +188,7 @@ func fetchElements(table *db.Table, pool *pgxpool.Pool) (elements []string, err
 	}
 
 	rows, err := pool.Query(
-		context.TODO(),
+		ctx,
 		`SELECT column_name FROM information_schema.columns
             WHERE table_name = $1
             AND NOT column_name = ANY($2::text[])
@@ -134,8 +215,8 @@ func fetchElements(table *db.Table, pool *pgxpool.Pool) (elements []string, err
 }
 
 // Fetches station numbers and filters them based on user input
-func getStations(table *db.Table, pool *pgxpool.Pool, config *Config) ([]string, error) {
-	stations, err := fetchStnrFromElemTable(table, pool)
+func getStations(ctx context.Context, table *db.Table, pool *pgxpool.Pool, config *Config) ([]string, error) {
+	stations, err := fetchStnrFromElemTable(ctx, table, pool)
 	if err != nil {
 		return nil, err
 	}
@@ -150,16 +231,16 @@ func getStations(table *db.Table, pool *pgxpool.Pool, config *Config) ([]string,
 }
 
 // This function uses the ELEM table to fetch the station numbers
-func fetchStnrFromElemTable(table *db.Table, pool *pgxpool.Pool) (stations []string, err error) {
+func fetchStnrFromElemTable(ctx context.Context, table *db.Table, pool *pgxpool.Pool) (stations []string, err error) {
 	slog.Info(fmt.Sprint("Fetching station numbers..."))
 
 	var rows pgx.Rows
 	if table.ElemTableName == "T_ELEM_OBS" {
 		query := `SELECT DISTINCT stnr FROM t_elem_obs WHERE table_name = $1`
-		rows, err = pool.Query(context.TODO(), query, table.TableName)
+		rows, err = pool.Query(ctx, query, table.TableName)
 	} else {
 		query := fmt.Sprintf("SELECT DISTINCT stnr FROM %s", strings.ToLower(table.ElemTableName))
-		rows, err = pool.Query(context.TODO(), query)
+		rows, err = pool.Query(ctx, query)
 	}
 
 	if err != nil {
@@ -2,6 +2,8 @@ package dump
 
 import (
 	"context"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"slices"
@@ -9,32 +11,119 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	"migrate/kdvh/db"
+	"migrate/metrics"
+	"migrate/report"
 	"migrate/utils"
 )
 
 type Config struct {
-	Path      string   `arg:"-p" default:"./dumps/kdvh" help:"Location the dumped data will be stored in"`
-	Tables    []string `arg:"-t" help:"Optional space separated list of table names"`
-	Stations  []string `arg:"-s" help:"Optional space separated list of stations IDs"`
-	Elements  []string `arg:"-e" help:"Optional space separated list of element codes"`
-	Overwrite bool     `help:"Overwrite any existing dumped files"`
-	MaxConn   int      `arg:"-n" default:"4" help:"Max number of allowed concurrent connections to KDVH"`
+	Path               string            `arg:"-p" default:"./dumps/kdvh" help:"Location the dumped data will be stored in"`
+	Tables             []string          `arg:"-t" help:"Optional space separated list of table names"`
+	Stations           []string          `arg:"-s" help:"Optional space separated list of stations IDs"`
+	Elements           []string          `arg:"-e" help:"Optional space separated list of element codes"`
+	Overwrite          bool              `help:"Overwrite any existing dumped files"`
+	Incremental        bool              `help:"Only dump the rows newer than the ones already dumped, to new segment files. For tables split in time windows, the segments are written in the window of the new rows"`
+	Format             utils.Format      `default:"csv" help:"Format of the dumped files. Choices: ['csv', 'parquet']"`
+	IncludeQuarantined bool              `arg:"--include-quarantined" help:"Also dump the 'kopi' and other invalid columns to a separate '_quarantine' directory, with a report of the rows of each column"`
+	Compress           utils.Compression `help:"Compress the dumped files. Parquet files compress their columns instead. Choices: ['gzip', 'zstd']"`
+	MaxConn            int               `arg:"-n" default:"4" help:"Max number of allowed concurrent connections to KDVH"`
+	MetricsAddr        string            `arg:"--metrics-addr" help:"Serve Prometheus metrics at this address (e.g. ':9090')"`
 }
 
-func (config *Config) Execute() {
-	pool, err := pgxpool.New(context.Background(), os.Getenv(db.KDVH_ENV_VAR))
-	if err != nil {
-		slog.Error(err.Error())
-		return
+var INCREMENTAL_OVERWRITE_ERR error = errors.New("--incremental and --overwrite cannot be used together")
+
+// Checks the arguments that go-arg cannot validate
+func (config *Config) Validate() error {
+	if config.Incremental && config.Overwrite {
+		return INCREMENTAL_OVERWRITE_ERR
+	}
+	return nil
+}
+
+// Dumps the selected tables. Returns an error if a table could not be dumped or if some series failed
+func (config *Config) Execute(ctx context.Context) (err error) {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	if config.MetricsAddr != "" {
+		if err := metrics.Serve(config.MetricsAddr); err != nil {
+			slog.Error(err.Error())
+			return err
+		}
 	}
 
+	// One pool for each source database, opened on first use
+	pools := make(map[string]*pgxpool.Pool)
+	defer func() {
+		for _, pool := range pools {
+			pool.Close()
+		}
+	}()
+
+	run := report.New("kdvh", "dump", config)
+	defer func() {
+		if err := run.Write(run.Filename()); err != nil {
+			slog.Error("Could not write run report: " + err.Error())
+		}
+		if err == nil {
+			err = run.Err()
+		}
+	}()
+
+	var errs []error
+
 	kdvh := db.Init()
 	for _, table := range kdvh.Tables {
+		if ctx.Err() != nil {
+			fmt.Println("Dump interrupted")
+			return errors.Join(errs...)
+		}
+
 		if len(config.Tables) > 0 && !slices.Contains(config.Tables, table.TableName) {
 			continue
 		}
 
+		pool, err := connect(ctx, table, pools)
+		if errors.Is(err, NO_SOURCE_ERR) && table.ConnEnvVar != db.KDVH_ENV_VAR && len(config.Tables) == 0 {
+			// Tables outside the proxy are only dumped if their source is configured, or if they are selected explicitly
+			slog.Warn(fmt.Sprintf("Skipping %s: %s", table.TableName, err))
+			continue
+		} else if err != nil {
+			slog.Error(fmt.Sprintf("%s: %s", table.TableName, err))
+			fmt.Printf("Could not dump %s: %s\n", table.TableName, err)
+			errs = append(errs, fmt.Errorf("%s: %w", table.TableName, err))
+			continue
+		}
+
 		utils.SetLogFile(table.TableName, "dump")
-		DumpTable(table, pool, config)
+		DumpTable(ctx, table, pool, config, run.NewTable(table.TableName))
+
+		if config.IncludeQuarantined && ctx.Err() == nil {
+			DumpQuarantine(ctx, table, pool, config, run.NewTable(table.TableName+"_QUARANTINE"))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var NO_SOURCE_ERR error = errors.New("connection string not set")
+
+// Returns the pool of the database where the table is stored, opening it if needed
+func connect(ctx context.Context, table *db.Table, pools map[string]*pgxpool.Pool) (*pgxpool.Pool, error) {
+	if pool, ok := pools[table.ConnEnvVar]; ok {
+		return pool, nil
+	}
+
+	connString := os.Getenv(table.ConnEnvVar)
+	if connString == "" {
+		return nil, fmt.Errorf("%w: %s", NO_SOURCE_ERR, table.ConnEnvVar)
 	}
+
+	pool, err := pgxpool.New(ctx, connString)
+	if err != nil {
+		return nil, err
+	}
+
+	pools[table.ConnEnvVar] = pool
+	return pool, nil
 }
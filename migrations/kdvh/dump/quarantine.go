@@ -0,0 +1,140 @@
+package dump
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"migrate/kdvh/db"
+	"migrate/manifest"
+	"migrate/report"
+)
+
+// Summary of the dumped quarantined columns, saved in the quarantine directory of the table
+const QUARANTINE_REPORT = "quarantine_report.csv"
+
+// Dumps the columns that are never imported (QUARANTINED_COLUMNS and the 'kopi' columns)
+// to the quarantine tree, see `db.Table.Quarantine`. A report with the number of dumped rows
+// per column is written next to them, to decide which ones should be kept.
+func DumpQuarantine(ctx context.Context, table *db.Table, pool *pgxpool.Pool, config *Config, tableReport *report.Table) {
+	// NOTE: T_HOMOGEN_MONTH does not have element columns, see `dumpHomogenMonth`
+	if table.TableName == "T_HOMOGEN_MONTH" {
+		tableReport.Finish()
+		return
+	}
+
+	quarantine := table.Quarantine()
+	fmt.Printf("Dumping quarantined columns of %s...\n", table.TableName)
+	dumpTable(ctx, quarantine, fetchQuarantinedElements, pool, config, tableReport)
+
+	dir := filepath.Join(config.Path, quarantine.Path)
+	counts, err := countQuarantined(dir)
+	if err == nil {
+		err = writeQuarantineReport(filepath.Join(dir, QUARANTINE_REPORT), counts)
+	}
+	if err != nil {
+		slog.Error(fmt.Sprintf("%s: could not write quarantine report - %s", table.TableName, err))
+		return
+	}
+
+	for _, count := range counts {
+		fmt.Printf("    %-20s %6d stations %12d rows\n", count.Column, count.Stations, count.Rows)
+	}
+}
+
+// Fetch the names of the quarantined columns of the table
+func fetchQuarantinedElements(ctx context.Context, table *db.Table, pool *pgxpool.Pool) (elements []string, err error) {
+	slog.Info(fmt.Sprintf("Fetching quarantined columns for %s...", table.TableName))
+
+	rows, err := pool.Query(
+		ctx,
+		`SELECT column_name FROM information_schema.columns
+            WHERE table_name = $1
+            AND (column_name = ANY($2::text[]) OR column_name LIKE '%kopi%')`,
+		// NOTE: needs to be lowercase with PG
+		strings.ToLower(table.TableName),
+		QUARANTINED_COLUMNS,
+	)
+	if err != nil {
+		slog.Error(fmt.Sprintf("Could not fetch quarantined columns for table %s: %v", table.TableName, err))
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			slog.Error(fmt.Sprintf("Could not fetch quarantined columns for table %s: %v", table.TableName, err))
+			return nil, err
+		}
+		elements = append(elements, name)
+	}
+	return elements, rows.Err()
+}
+
+// Number of dumped rows of a quarantined column
+type columnCount struct {
+	Column   string
+	Stations int
+	Rows     int64
+}
+
+// Sums the rows of each column found in the dump manifest, so the report
+// also includes the series dumped by previous runs
+func countQuarantined(dir string) ([]columnCount, error) {
+	entries, err := manifest.Load(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows := make(map[string]int64)
+	stations := make(map[string]map[int32]bool)
+	for _, entry := range entries {
+		// Incremental dumps can write multiple files for the same series
+		column := strings.ToLower(entry.Series)
+		if stations[column] == nil {
+			stations[column] = make(map[int32]bool)
+		}
+		stations[column][entry.Station] = true
+		rows[column] += entry.Rows
+	}
+
+	counts := make([]columnCount, 0, len(rows))
+	for column, n := range rows {
+		counts = append(counts, columnCount{column, len(stations[column]), n})
+	}
+
+	slices.SortFunc(counts, func(a, b columnCount) int { return strings.Compare(a.Column, b.Column) })
+	return counts, nil
+}
+
+func writeQuarantineReport(filename string, counts []columnCount) error {
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	writer := csv.NewWriter(file)
+	writer.Write([]string{"column", "stations", "rows"})
+	for _, count := range counts {
+		writer.Write([]string{count.Column, strconv.Itoa(count.Stations), strconv.FormatInt(count.Rows, 10)})
+	}
+	writer.Flush()
+
+	err = writer.Error()
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
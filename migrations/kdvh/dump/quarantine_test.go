@@ -0,0 +1,45 @@
+package dump
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"migrate/manifest"
+)
+
+func TestCountQuarantined(t *testing.T) {
+	dir := t.TempDir()
+	m, err := manifest.Open(dir, "T_ADATA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entries := []manifest.Entry{
+		{Station: 18700, Series: "tam_kopi", Rows: 10},
+		{Station: 18700, Series: "tam_kopi", Rows: 2}, // Incremental segment
+		{Station: 50540, Series: "tam_kopi", Rows: 5},
+		{Station: 18700, Series: "typeid", Rows: 7},
+	}
+	for i, entry := range entries {
+		filename := filepath.Join(dir, "file"+string(rune('a'+i))+".csv")
+		if err := os.WriteFile(filename, nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := m.Add(filename, entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.Close()
+
+	counts, err := countQuarantined(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []columnCount{{"tam_kopi", 2, 17}, {"typeid", 1, 7}}
+	if !slices.Equal(counts, expected) {
+		t.Errorf("Got %v, wanted %v", counts, expected)
+	}
+}
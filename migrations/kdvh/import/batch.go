@@ -0,0 +1,186 @@
+package port
+
+import (
+	"log/slog"
+	"time"
+
+	kdvh "migrate/kdvh/db"
+	"migrate/lard"
+	"migrate/report"
+)
+
+// LARD table a convertSource produces rows for
+type target int
+
+const (
+	DATA_ROWS target = iota
+	TEXT_ROWS
+	FLAG_ROWS
+)
+
+// Implements lard.Source, converting the observations with the table ConvertFunction
+// only when pgx asks for the next row, so the converted rows are never all kept in memory
+type convertSource struct {
+	obs     []kdvh.KdvhObs
+	tsInfo  *kdvh.TsInfo
+	convert kdvh.ConvertFunction
+	target  target
+	index   int
+	row     []any
+	err     error
+}
+
+func newConvertSource(obs []kdvh.KdvhObs, tsInfo *kdvh.TsInfo, table *kdvh.Table, target target) *convertSource {
+	return &convertSource{obs: obs, tsInfo: tsInfo, convert: table.Convert, target: target}
+}
+
+func (s *convertSource) Next() bool {
+	if s.err != nil || s.index >= len(s.obs) {
+		return false
+	}
+
+	// ConvertFunctions take a pointer to the observation, so each source works on a copy
+	obs := s.obs[s.index]
+	s.index += 1
+
+	data, text, flag, err := s.convert(&obs, s.tsInfo)
+	if err != nil {
+		s.err = err
+		return false
+	}
+
+	switch s.target {
+	case DATA_ROWS:
+		s.row = data.ToRow()
+	case TEXT_ROWS:
+		s.row = text.ToRow()
+	case FLAG_ROWS:
+		s.row = flag.ToRow()
+	}
+	return true
+}
+
+func (s *convertSource) Values() ([]any, error) {
+	return s.row, nil
+}
+
+func (s *convertSource) Err() error {
+	return s.err
+}
+
+func (s *convertSource) Len() int {
+	return len(s.obs)
+}
+
+// Builds the sources for the LARD tables the observations of the timeseries are inserted into
+func newBatch(obs []kdvh.KdvhObs, tsInfo *kdvh.TsInfo, table *kdvh.Table) *lard.Batch {
+	if !tsInfo.Param.IsScalar {
+		return &lard.Batch{Text: newConvertSource(obs, tsInfo, table, TEXT_ROWS)}
+	}
+	return &lard.Batch{
+		Data:  newConvertSource(obs, tsInfo, table, DATA_ROWS),
+		Flags: newConvertSource(obs, tsInfo, table, FLAG_ROWS),
+	}
+}
+
+// Streams the observations of the file that should be imported, passing them to `flush`
+// in batches of at most `config.BatchSize` observations (or all of them, if the batch size is not positive).
+// The slice passed to `flush` is reused for the next batch.
+// Observations at or before `after`, which were committed by a previous run, are skipped
+func readBatches(filename string, tsInfo *kdvh.TsInfo, table *kdvh.Table, config *Config, stats *report.Series, after *time.Time, flush func([]kdvh.KdvhObs) error) error {
+	// Handles Parquet files, and both the trailer layout and the header layout of older CSV dumps
+	reader, err := kdvh.OpenObsReader(filename, config.Sep)
+	if err != nil {
+		slog.Warn(err.Error())
+		return err
+	}
+	defer reader.Close()
+
+	var maxYearReached bool
+	var flushed, resumed int
+	var batch []kdvh.KdvhObs
+
+	for reader.Next() {
+		// The header of older dumps is parsed on the first call to Next
+		if batch == nil {
+			size := reader.SizeHint()
+			if config.BatchSize > 0 {
+				size = min(size, config.BatchSize)
+			}
+			batch = make([]kdvh.KdvhObs, 0, size)
+		}
+
+		stats.RowsRead += 1
+		obs, err := reader.Obs()
+		if err != nil {
+			return err
+		}
+		obsTime := obs.Obstime
+
+		if after != nil && !obsTime.After(*after) {
+			stats.Skip(report.ALREADY_IMPORTED)
+			resumed += 1
+			continue
+		}
+
+		// Only import data between KDVH's defined fromtime and totime
+		if tsInfo.Timespan.From != nil && obsTime.Sub(*tsInfo.Timespan.From) < 0 {
+			stats.Skip(report.BEFORE_FROMTIME)
+			continue
+		} else if tsInfo.Timespan.To != nil && obsTime.Sub(*tsInfo.Timespan.To) > 0 {
+			skipRemaining(reader, stats, report.AFTER_TOTIME)
+			break
+		}
+
+		if table.MaxImportYearReached(obsTime.Year()) {
+			maxYearReached = true
+			skipRemaining(reader, stats, report.PAST_IMPORT_YEAR)
+			break
+		}
+
+		batch = append(batch, *obs)
+		if config.BatchSize > 0 && len(batch) == config.BatchSize {
+			if err := flush(batch); err != nil {
+				return err
+			}
+			flushed += len(batch)
+			batch = batch[:0]
+		}
+	}
+
+	if err := reader.Err(); err != nil {
+		slog.Error(tsInfo.Logstr + err.Error())
+		return err
+	}
+
+	if len(batch) > 0 {
+		if err := flush(batch); err != nil {
+			return err
+		}
+		flushed += len(batch)
+	}
+
+	// All the rows were committed by a previous run, which stopped before marking the series as done
+	if flushed == 0 && resumed > 0 {
+		return nil
+	}
+
+	if flushed == 0 {
+		if maxYearReached {
+			slog.Info(tsInfo.Logstr + "no rows to insert (all obstimes > max import time)")
+			return MAX_IMPORT_YEAR_ERR
+		}
+		slog.Info(tsInfo.Logstr + "no rows to insert")
+		return NO_ROWS_ERR
+	}
+	return nil
+}
+
+// Counts the current and remaining lines of the file as skipped, without parsing them
+func skipRemaining(reader kdvh.ObsReader, stats *report.Series, reason string) {
+	stats.Skip(reason)
+	for reader.Next() {
+		stats.RowsRead += 1
+		stats.Skip(reason)
+	}
+}
@@ -0,0 +1,76 @@
+package port
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	kdvh "migrate/kdvh/db"
+	"migrate/report"
+	"migrate/stinfosys"
+)
+
+func TestReadBatches(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "TA.csv")
+	content := "2001-07-01_00:00:00,12.9,70000\n" +
+		"2001-07-01_01:00:00,13.1,70000\n" +
+		"2001-07-01_02:00:00,13.4,70000\n" +
+		"2001-07-01_03:00:00,13.0,70000\n" +
+		"2001-07-01_04:00:00,12.2,70000\n" +
+		"# rows: 5\n"
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	table := kdvh.NewTable("T_ADATA", "T_ADATA_FLAG", "T_ELEM_OBS").SetImportYear(3000)
+	tsInfo := &kdvh.TsInfo{Id: 1, Station: 18700, Element: "TA", Param: stinfosys.Param{IsScalar: true}}
+	after := time.Date(2001, 7, 1, 2, 0, 0, 0, time.UTC)
+	last := time.Date(2001, 7, 1, 4, 0, 0, 0, time.UTC)
+
+	type testCase struct {
+		name      string
+		batchSize int
+		after     *time.Time
+		expected  []int
+	}
+
+	cases := []testCase{
+		{"batches of 2", 2, nil, []int{2, 2, 1}},
+		{"single batch", 0, nil, []int{5}},
+		{"resumed", 2, &after, []int{2}},
+		{"all committed", 2, &last, nil},
+	}
+
+	for _, c := range cases {
+		t.Log("Testing:", c.name)
+
+		config := &Config{Sep: ",", BatchSize: c.batchSize}
+		stats := report.New("kdvh", "import", config).NewTable(table.TableName).NewSeries(18700, "TA")
+
+		var sizes []int
+		err := readBatches(filename, tsInfo, table, config, stats, c.after, func(obs []kdvh.KdvhObs) error {
+			// Every observation is converted once for each LARD table
+			batch := newBatch(obs, tsInfo, table)
+			for _, source := range []*convertSource{batch.Data.(*convertSource), batch.Flags.(*convertSource)} {
+				var rows int
+				for source.Next() {
+					rows++
+				}
+				if source.Err() != nil || rows != len(obs) {
+					t.Errorf("Got %d converted rows (%v), wanted %d", rows, source.Err(), len(obs))
+				}
+			}
+			sizes = append(sizes, len(obs))
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if !slices.Equal(sizes, c.expected) {
+			t.Errorf("Got batches %v, wanted %v", sizes, c.expected)
+		}
+	}
+}
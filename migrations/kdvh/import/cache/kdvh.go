@@ -2,10 +2,13 @@ package cache
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
 	"os"
+	"path/filepath"
 	"slices"
+	"strconv"
 	"time"
 
 	"github.com/jackc/pgx/v5"
@@ -24,30 +27,55 @@ type KDVHKey struct {
 	Station int32
 }
 
-func newKDVHKey(elem, table string, stnr int32) KDVHKey {
+func NewKDVHKey(elem, table string, stnr int32) KDVHKey {
 	return KDVHKey{stinfosys.Key{ElemCode: elem, TableName: table}, stnr}
 }
 
-// Cache timeseries timespan from KDVH
-func cacheKDVH(tables, stations, elements []string, database *kdvh.KDVH) KDVHMap {
-	cache := make(KDVHMap)
-
-	slog.Info("Connecting to KDVH proxy to cache metadata")
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
-	defer cancel()
+// Source reported in the errors of each KDVH database, keyed by the env variable of its connection string
+var KDVH_SOURCES = map[string]string{
+	kdvh.KDVH_ENV_VAR:     utils.KDVH_PROXY,
+	kdvh.AVINOR_ENV_VAR:   utils.KDVH_AVINOR,
+	kdvh.PROJDATA_ENV_VAR: utils.KDVH_PROJDATA,
+}
 
-	conn, err := pgx.Connect(ctx, os.Getenv(kdvh.KDVH_ENV_VAR))
-	if err != nil {
-		slog.Error("Could not connect to KDVH proxy. Make sure to be connected to the VPN: " + err.Error())
-		os.Exit(1)
+func kdvhSource(envVar string) string {
+	if source, ok := KDVH_SOURCES[envVar]; ok {
+		return source
 	}
-	defer conn.Close(context.TODO())
+	return envVar
+}
+
+// Cache timeseries timespan from KDVH. The ELEM tables are read from the source database of each table
+func cacheKDVH(ctx context.Context, tables, stations, elements []string, database *kdvh.KDVH) (KDVHMap, error) {
+	cache := make(KDVHMap)
+
+	conns := make(map[string]*pgx.Conn)
+	defer func() {
+		for _, conn := range conns {
+			conn.Close(context.WithoutCancel(ctx))
+		}
+	}()
 
 	for _, t := range database.Tables {
 		if len(tables) > 0 && !slices.Contains(tables, t.TableName) {
 			continue
 		}
 
+		conn, ok := conns[t.ConnEnvVar]
+		if !ok {
+			// Timespans are optional, skip the tables outside the proxy if their source is not configured
+			if t.ConnEnvVar != kdvh.KDVH_ENV_VAR && os.Getenv(t.ConnEnvVar) == "" {
+				slog.Warn(fmt.Sprintf("%s: %s not set, skipping timespans", t.TableName, t.ConnEnvVar))
+				continue
+			}
+
+			var err error
+			if conn, err = connectKDVH(ctx, t.ConnEnvVar); err != nil {
+				return nil, err
+			}
+			conns[t.ConnEnvVar] = conn
+		}
+
 		// TODO: probably need to sanitize these inputs
 		query := fmt.Sprintf(
 			`SELECT table_name, stnr, elem_code, fdato, tdato FROM %s
@@ -56,10 +84,10 @@ func cacheKDVH(tables, stations, elements []string, database *kdvh.KDVH) KDVHMap
 			t.ElemTableName,
 		)
 
-		rows, err := conn.Query(context.TODO(), query, stations, elements)
+		source := kdvhSource(t.ConnEnvVar)
+		rows, err := conn.Query(ctx, query, stations, elements)
 		if err != nil {
-			slog.Error(err.Error())
-			os.Exit(1)
+			return nil, utils.NewSourceError(source, err)
 		}
 
 		for rows.Next() {
@@ -75,19 +103,67 @@ func cacheKDVH(tables, stations, elements []string, database *kdvh.KDVH) KDVHMap
 			)
 
 			if err != nil {
-				slog.Error(err.Error())
-				os.Exit(1)
+				rows.Close()
+				return nil, utils.NewSourceError(source, err)
 			}
 
 			cache[key] = span
 		}
 
 		if rows.Err() != nil {
-			slog.Error(rows.Err().Error())
-			os.Exit(1)
+			return nil, utils.NewSourceError(source, rows.Err())
+		}
+	}
+
+	return cache, nil
+}
+
+func connectKDVH(ctx context.Context, envVar string) (*pgx.Conn, error) {
+	slog.Info("Connecting to " + envVar + " to cache metadata")
+	connCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
+	defer cancel()
+
+	conn, err := pgx.Connect(connCtx, os.Getenv(envVar))
+	if err != nil {
+		err = fmt.Errorf("could not connect, make sure to be connected to the VPN - %w", err)
+		return nil, utils.NewSourceError(kdvhSource(envVar), err)
+	}
+	return conn, nil
+}
+
+// Same as cacheKDVH, but reads the timespans from the ELEM snapshots saved by `kdvh dump` in `dir`
+func loadKDVHSnapshot(dir string, tables, stations, elements []string, database *kdvh.KDVH) (KDVHMap, error) {
+	cache := make(KDVHMap)
+
+	slog.Info("Reading KDVH metadata from " + dir)
+	for _, t := range database.Tables {
+		if len(tables) > 0 && !slices.Contains(tables, t.TableName) {
+			continue
+		}
+
+		if t.ElemTableName == "" {
+			continue
+		}
+
+		elems, err := kdvh.ReadElem(filepath.Join(dir, t.Path))
+		if errors.Is(err, os.ErrNotExist) {
+			// The table was not dumped, or it was dumped before the snapshots were introduced
+			slog.Warn(fmt.Sprintf("%s: missing %s, re-run `kdvh dump` to create it", t.TableName, kdvh.ELEM_FILENAME))
+			continue
+		} else if err != nil {
+			return nil, err
 		}
 
+		for _, elem := range elems {
+			if !utils.IsEmptyOrContains(stations, strconv.Itoa(int(elem.Station))) ||
+				!utils.IsEmptyOrContains(elements, elem.Element) {
+				continue
+			}
+
+			key := NewKDVHKey(elem.Element, t.TableName, elem.Station)
+			cache[key] = utils.TimeSpan{From: elem.Fdato, To: elem.Tdato}
+		}
 	}
 
-	return cache
+	return cache, nil
 }
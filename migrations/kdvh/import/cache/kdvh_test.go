@@ -0,0 +1,41 @@
+package cache
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	kdvh "migrate/kdvh/db"
+	"migrate/utils"
+)
+
+func TestCacheKDVHSourceError(t *testing.T) {
+	type testCase struct {
+		table    *kdvh.Table
+		expected string
+	}
+
+	cases := []testCase{
+		{kdvh.NewTable("T_ADATA", "T_AFLAG", "T_ELEM_OBS"), utils.KDVH_PROXY},
+		{kdvh.NewTable("T_AVINOR", "T_AVINOR_FLAG", "T_ELEM_OBS").SetSource(kdvh.AVINOR_ENV_VAR), utils.KDVH_AVINOR},
+		{kdvh.NewTable("T_PROJDATA", "T_PROJFLAG", "T_ELEM_PROJ").SetSource(kdvh.PROJDATA_ENV_VAR), utils.KDVH_PROJDATA},
+	}
+
+	for _, c := range cases {
+		t.Log("Testing", c.table.TableName)
+
+		// Nothing listens on port 1, so the connection is refused right away
+		t.Setenv(c.table.ConnEnvVar, "postgres://user@127.0.0.1:1/db")
+		database := &kdvh.KDVH{Tables: map[string]*kdvh.Table{c.table.TableName: c.table}}
+
+		_, err := cacheKDVH(context.Background(), nil, nil, nil, database)
+
+		var sourceErr *utils.SourceError
+		if !errors.As(err, &sourceErr) {
+			t.Fatalf("Expected a SourceError, got %v", err)
+		}
+		if sourceErr.Source != c.expected {
+			t.Errorf("Got source %q, wanted %q", sourceErr.Source, c.expected)
+		}
+	}
+}
@@ -1,10 +1,12 @@
 package cache
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log/slog"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	kdvh "migrate/kdvh/db"
@@ -21,28 +23,88 @@ type Cache struct {
 }
 
 // Caches all the metadata needed for import of KDVH tables.
-// If any error occurs inside here the program will exit.
-func CacheMetadata(tables, stations, elements []string, database *kdvh.KDVH) *Cache {
-	stconn, ctx := stinfosys.Connect()
-	defer stconn.Close(ctx)
-
-	return &Cache{
-		Elements:  stinfosys.CacheElemMap(stconn),
-		Permits:   stinfosys.NewPermitTables(stconn),
-		Offsets:   cacheParamOffsets(),
-		Timespans: cacheKDVH(tables, stations, elements, database),
+// All the sources are queried even if one of them fails, and the returned error
+// joins a `utils.SourceError` for each source that broke.
+// If `snapshotDir` is not empty, the KDVH timespans are read from the ELEM snapshots
+// saved there by `kdvh dump`, instead of the KDVH proxy.
+// If `offsetsFile` is not empty, it replaces the embedded `product_offsets.csv`.
+func CacheMetadata(ctx context.Context, tables, stations, elements []string, database *kdvh.KDVH, snapshotDir, offsetsFile string) (*Cache, error) {
+	var cache Cache
+	var stinfoErr, permitErr, offsetErr, kdvhErr error
+
+	stconn, stinfoErr := stinfosys.Connect(ctx)
+	if stinfoErr == nil {
+		defer stconn.Close(context.WithoutCancel(ctx))
+
+		cache.Elements, stinfoErr = stinfosys.CacheElemMap(ctx, stconn)
+		cache.Permits, permitErr = stinfosys.NewPermitTables(ctx, stconn)
+	}
+
+	cache.Offsets, offsetErr = cacheParamOffsets(offsetsFile)
+	if snapshotDir != "" {
+		cache.Timespans, kdvhErr = loadKDVHSnapshot(snapshotDir, tables, stations, elements, database)
+	} else {
+		cache.Timespans, kdvhErr = cacheKDVH(ctx, tables, stations, elements, database)
+	}
+
+	if err := errors.Join(stinfoErr, permitErr, offsetErr, kdvhErr); err != nil {
+		return nil, err
+	}
+	return &cache, nil
+}
+
+var (
+	NO_METADATA_ERR error = errors.New("No metadata")
+	RESTRICTED_ERR  error = errors.New("Restricted data")
+)
+
+// Returns the metadata for the timeseries, inserting it in LARD if it does not exist yet
+func (cache *Cache) NewTsInfo(ctx context.Context, table, element string, station int32, pool *pgxpool.Pool) (*kdvh.TsInfo, error) {
+	tsInfo, label, err := cache.resolveTsInfo(table, element, station)
+	if err != nil {
+		return nil, err
+	}
+
+	// TODO: are Param.Fromtime and Span.From different?
+	tsid, err := lard.GetTimeseriesID(ctx, label, utils.TimeSpan{From: &tsInfo.Param.Fromtime, To: tsInfo.Timespan.To}, pool)
+	if err != nil {
+		slog.Error(tsInfo.Logstr + "could not obtain timeseries - " + err.Error())
+		return nil, err
+	}
+
+	tsInfo.Id = tsid
+	return tsInfo, nil
+}
+
+// Same as NewTsInfo, but it never inserts a new timeseries in LARD.
+// `exists` is false if the timeseries would need to be created, in which case the ID is zero.
+func (cache *Cache) LookupTsInfo(ctx context.Context, table, element string, station int32, pool *pgxpool.Pool) (tsInfo *kdvh.TsInfo, exists bool, err error) {
+	tsInfo, label, err := cache.resolveTsInfo(table, element, station)
+	if err != nil {
+		return nil, false, err
 	}
+
+	tsInfo.Id, err = lard.FindTimeseriesID(ctx, label, pool)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return tsInfo, false, nil
+	} else if err != nil {
+		slog.Error(tsInfo.Logstr + "could not obtain timeseries - " + err.Error())
+		return nil, false, err
+	}
+
+	return tsInfo, true, nil
 }
 
-func (cache *Cache) NewTsInfo(table, element string, station int32, pool *pgxpool.Pool) (*kdvh.TsInfo, error) {
+// Collects the cached metadata for the timeseries
+func (cache *Cache) resolveTsInfo(table, element string, station int32) (*kdvh.TsInfo, *lard.Label, error) {
 	logstr := fmt.Sprintf("[%v - %v - %v]: ", table, station, element)
-	key := newKDVHKey(element, table, station)
+	key := NewKDVHKey(element, table, station)
 
 	param, ok := cache.Elements[key.Inner]
 	if !ok {
 		// TODO: should it fail here? How do we deal with data without metadata?
 		slog.Error(logstr + "Missing metadata in Stinfosys")
-		return nil, errors.New("No metadata")
+		return nil, nil, NO_METADATA_ERR
 	}
 
 	// Check if data for this station/element is restricted
@@ -50,14 +112,14 @@ func (cache *Cache) NewTsInfo(table, element string, station int32, pool *pgxpoo
 	isOpen := cache.Permits.TimeseriesIsOpen(station, param.TypeID, param.ParamID)
 	if !isOpen {
 		slog.Warn(logstr + "Timeseries data is restricted")
-		return nil, errors.New("Restricted data")
+		return nil, nil, RESTRICTED_ERR
 	}
 
 	// No need to check for `!ok`, will default to 0 offset
 	offset := cache.Offsets[key.Inner]
 
 	// No need to check for `!ok`, timespan will be ignored if not in the map
-	timespan, ok := cache.Timespans[key]
+	timespan := cache.Timespans[key]
 
 	label := lard.Label{
 		StationID: station,
@@ -67,20 +129,13 @@ func (cache *Cache) NewTsInfo(table, element string, station int32, pool *pgxpoo
 		Level:     param.Hlevel,
 	}
 
-	// TODO: are Param.Fromtime and Span.From different?
-	tsid, err := lard.GetTimeseriesID(&label, utils.TimeSpan{From: &param.Fromtime, To: timespan.To}, pool)
-	if err != nil {
-		slog.Error(logstr + "could not obtain timeseries - " + err.Error())
-		return nil, err
-	}
-
 	return &kdvh.TsInfo{
-		Id:       tsid,
-		Station:  station,
-		Element:  element,
-		Offset:   offset,
-		Param:    param,
-		Timespan: timespan,
-		Logstr:   logstr,
-	}, nil
+		Station:   station,
+		Element:   element,
+		Offset:    offset,
+		Param:     param,
+		Timespan:  timespan,
+		Transform: kdvh.LookupTransform(table, element),
+		Logstr:    logstr,
+	}, &label, nil
 }
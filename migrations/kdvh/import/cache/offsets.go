@@ -1,66 +1,43 @@
 package cache
 
 import (
-	"log/slog"
+	"fmt"
+
+	kdvh "migrate/kdvh/db"
 	"migrate/stinfosys"
-	"os"
+	"migrate/utils"
 
-	"github.com/gocarina/gocsv"
 	"github.com/rickb777/period"
 )
 
 // Map of offsets used to correct KDVH times for specific parameters
 type OffsetMap = map[stinfosys.Key]period.Period
 
-// Caches how to modify the obstime (in KDVH) for certain paramids
-func cacheParamOffsets() OffsetMap {
+// Caches how to modify the obstime (in KDVH) for certain paramids.
+// The offsets are read from `filename`, or from the embedded `product_offsets.csv` if it is empty
+func cacheParamOffsets(filename string) (OffsetMap, error) {
 	cache := make(OffsetMap)
 
-	type CSVRow struct {
-		TableName      string `csv:"table_name"`
-		ElemCode       string `csv:"elem_code"`
-		ParamID        int32  `csv:"paramid"`
-		FromtimeOffset string `csv:"fromtime_offset"`
-		Timespan       string `csv:"timespan"`
-	}
-
-	csvfile, err := os.Open("kdvh/product_offsets.csv")
+	rows, err := kdvh.ReadOffsetRows(filename)
 	if err != nil {
-		slog.Error(err.Error())
-		os.Exit(1)
+		return nil, utils.NewSourceError(utils.OFFSETS_CSV, err)
 	}
-	defer csvfile.Close()
 
-	var csvrows []CSVRow
-	if err := gocsv.UnmarshalFile(csvfile, &csvrows); err != nil {
-		slog.Error(err.Error())
-		os.Exit(1)
-	}
-
-	for _, row := range csvrows {
-		var fromtimeOffset, timespan period.Period
-		if row.FromtimeOffset != "" {
-			fromtimeOffset, err = period.Parse(row.FromtimeOffset)
-			if err != nil {
-				slog.Error(err.Error())
-				os.Exit(1)
-			}
-		}
-		if row.Timespan != "" {
-			timespan, err = period.Parse(row.Timespan)
-			if err != nil {
-				slog.Error(err.Error())
-				os.Exit(1)
-			}
+	for i, row := range rows {
+		key := stinfosys.Key{ElemCode: row.ElemCode, TableName: row.TableName}
+		if _, ok := cache[key]; ok {
+			err := fmt.Errorf("line %d: duplicated offset for %s %s", kdvh.OffsetLine(i), row.TableName, row.ElemCode)
+			return nil, utils.NewSourceError(utils.OFFSETS_CSV, err)
 		}
-		migrationOffset, err := fromtimeOffset.Add(timespan)
+
+		migrationOffset, err := row.Offset()
 		if err != nil {
-			slog.Error(err.Error())
-			os.Exit(1)
+			err = fmt.Errorf("line %d: %w", kdvh.OffsetLine(i), err)
+			return nil, utils.NewSourceError(utils.OFFSETS_CSV, err)
 		}
 
-		cache[stinfosys.Key{ElemCode: row.ElemCode, TableName: row.TableName}] = migrationOffset
+		cache[key] = migrationOffset
 	}
 
-	return cache
+	return cache, nil
 }
@@ -1,9 +1,10 @@
 package port
 
 import (
-	"bufio"
+	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
@@ -11,29 +12,138 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"migrate/checkpoint"
+	"migrate/dryrun"
 	kdvh "migrate/kdvh/db"
 	"migrate/kdvh/import/cache"
-	"migrate/lard"
+	"migrate/metrics"
+	"migrate/report"
 	"migrate/utils"
 )
 
+// Elements that are not imported, unless a conversion is registered for them in `kdvh.TRANSFORMS`
 // TODO: add CALL_SIGN? It's not in stinfosys?
 var INVALID_ELEMENTS = []string{"TYPEID", "TAM_NORMAL_9120", "RRA_NORMAL_9120", "OT", "OTN", "OTX", "DD06", "DD12", "DD18"}
 
-func ImportTable(table *kdvh.Table, cache *cache.Cache, pool *pgxpool.Pool, config *Config) (rowsInserted int64) {
+func ImportTable(ctx context.Context, table *kdvh.Table, cache *cache.Cache, pool *pgxpool.Pool, config *Config, tableReport *report.Table) (rowsInserted int64) {
 	fmt.Printf("Importing %s...\n", table.TableName)
 	defer fmt.Println(strings.Repeat("- ", 40))
+	defer tableReport.Finish()
 
+	tableMetrics := metrics.Table{Source: "kdvh", Procedure: "import", Name: tableReport.Name}
+	tableMetrics.Start()
+	defer tableMetrics.Finish()
+
+	// Tables that were not dumped are simply skipped
 	stations, err := os.ReadDir(filepath.Join(config.Path, table.Path))
 	if err != nil {
 		slog.Warn(err.Error())
+		if !errors.Is(err, fs.ErrNotExist) {
+			tableReport.Fail(err)
+		}
 		return 0
 	}
 
+	// The checkpoint manifest is left untouched during dry runs
+	var plan *dryrun.Report
+	var manifest *checkpoint.Manifest
+	if config.DryRun {
+		plan = &dryrun.Report{}
+		defer func() {
+			if err := plan.Write(dryrun.Filename(table.TableName)); err != nil {
+				slog.Error("Could not write dry-run report: " + err.Error())
+			}
+		}()
+	} else {
+		manifest, err = checkpoint.Open(filepath.Join(config.Path, table.Path, checkpoint.FILENAME), config.Resume)
+		if err != nil {
+			slog.Error("Could not open checkpoint manifest: " + err.Error())
+			tableReport.Fail(err)
+			return 0
+		}
+		defer manifest.Close()
+	}
+
+	jobs := collectJobs(stations, table, config)
+	bar := utils.NewBar(len(jobs), fmt.Sprintf("%10s", table.TableName))
+	bar.RenderBlank()
+
+	// Jobs are scheduled across the whole table, so at most `config.Workers` series are imported at once
+	queue := make(chan job)
+	var inserted atomic.Int64
+	var wg sync.WaitGroup
+	for id := range max(config.Workers, 1) {
+		worker := tableReport.NewWorker(id)
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				start := time.Now()
+				count := processJob(ctx, job, table, cache, pool, config, plan, manifest, tableReport)
+				worker.Record(count, time.Since(start))
+				inserted.Add(count)
+				bar.Add(1)
+			}
+		}()
+	}
+
+	for _, job := range jobs {
+		// Series that did not start yet are left for the next run
+		if ctx.Err() != nil {
+			slog.Warn(fmt.Sprintf("%s: import interrupted", table.TableName))
+			break
+		}
+		queue <- job
+	}
+	close(queue)
+	wg.Wait()
+
+	rowsInserted = inserted.Load()
+	outputStr := fmt.Sprintf("%v: %v total rows inserted", table.TableName, rowsInserted)
+	slog.Info(outputStr)
+	fmt.Println(outputStr)
+
+	return rowsInserted
+}
+
+// A (station, element) series to import, possibly split in multiple segment files
+// by time-partitioned and incremental dumps
+type job struct {
+	stnr     int32
+	elemCode string
+	segments []kdvh.Segment // Sorted chronologically
+
+	// Metadata of the timeseries, shared by all the segments, see `timeseries`
+	tsInfo   *kdvh.TsInfo
+	exists   bool
+	tsErr    error
+	resolved bool
+}
+
+// Returns the metadata of the timeseries all the segments of the job are imported into.
+// It is resolved only once, when the first segment needs it, so the timeseries is also created only once.
+// During dry runs the timeseries is never created, and `exists` reports whether it is already in LARD
+func (j *job) timeseries(ctx context.Context, table *kdvh.Table, cache *cache.Cache, pool *pgxpool.Pool, dryRun bool) (tsInfo *kdvh.TsInfo, exists bool, err error) {
+	if !j.resolved {
+		if dryRun {
+			j.tsInfo, j.exists, j.tsErr = cache.LookupTsInfo(ctx, table.TableName, j.elemCode, j.stnr, pool)
+		} else {
+			j.tsInfo, j.tsErr = cache.NewTsInfo(ctx, table.TableName, j.elemCode, j.stnr, pool)
+			j.exists = j.tsErr == nil
+		}
+		j.resolved = true
+	}
+	return j.tsInfo, j.exists, j.tsErr
+}
+
+// Lists the element files of all the stations that should be imported
+func collectJobs(stations []os.DirEntry, table *kdvh.Table, config *Config) (jobs []job) {
 	for _, station := range stations {
 		stnr, err := getStationNumber(station, config.Stations)
 		if err != nil {
@@ -43,165 +153,255 @@ func ImportTable(table *kdvh.Table, cache *cache.Cache, pool *pgxpool.Pool, conf
 			continue
 		}
 
-		stationDir := filepath.Join(config.Path, table.Path, station.Name())
-		elements, err := os.ReadDir(stationDir)
+		segments, err := kdvh.ListSegments(filepath.Join(config.Path, table.Path, station.Name()))
 		if err != nil {
 			slog.Warn(err.Error())
 			continue
 		}
 
-		bar := utils.NewBar(len(elements), fmt.Sprintf("%10s", station.Name()))
-		bar.RenderBlank()
-
-		var wg sync.WaitGroup
-		for _, element := range elements {
-			wg.Add(1)
-			go func() {
-				defer func() {
-					bar.Add(1)
-					wg.Done()
-				}()
-
-				elemCode, err := getElementCode(element, config.Elements)
-				if err != nil {
-					if config.Verbose {
-						slog.Info(err.Error())
-					}
-					return
-				}
-
-				tsInfo, err := cache.NewTsInfo(table.TableName, elemCode, stnr, pool)
-				if err != nil {
-					return
-				}
-
-				filename := filepath.Join(stationDir, element.Name())
-				data, text, flag, err := parseData(filename, tsInfo, table, config)
-				if err != nil {
-					return
+		// All the segments of an element are imported by the same job, so the timeseries is only created once
+		var stationJobs []job
+		index := make(map[string]int)
+		for _, segment := range segments {
+			if err := checkElement(segment.Element, config.Elements, table); err != nil {
+				if config.Verbose {
+					slog.Info(err.Error())
 				}
+				continue
+			}
 
-				var count int64
-				if tsInfo.Param.IsScalar {
-					count, err = lard.InsertData(data, pool, tsInfo.Logstr)
-					if err != nil {
-						slog.Error(tsInfo.Logstr + "failed data bulk insertion - " + err.Error())
-						return
-					}
-					if err := lard.InsertFlags(flag, pool, tsInfo.Logstr); err != nil {
-						slog.Error(tsInfo.Logstr + "failed flag bulk insertion - " + err.Error())
-					}
-				} else {
-					count, err = lard.InsertTextData(text, pool, tsInfo.Logstr)
-					if err != nil {
-						slog.Error(tsInfo.Logstr + "failed non-scalar data bulk insertion - " + err.Error())
-						return
-					}
-				}
+			i, ok := index[segment.Element]
+			if !ok {
+				i = len(stationJobs)
+				index[segment.Element] = i
+				stationJobs = append(stationJobs, job{stnr: stnr, elemCode: segment.Element})
+			}
+			stationJobs[i].segments = append(stationJobs[i].segments, segment)
+		}
 
-				rowsInserted += count
-			}()
+		for _, job := range stationJobs {
+			slices.SortFunc(job.segments, kdvh.CompareSegments)
 		}
-		wg.Wait()
+		jobs = append(jobs, stationJobs...)
 	}
+	return jobs
+}
 
-	outputStr := fmt.Sprintf("%v: %v total rows inserted", table.TableName, rowsInserted)
-	slog.Info(outputStr)
-	fmt.Println(outputStr)
-
-	return rowsInserted
+// Imports (or plans, during dry runs) all the segments of a job. Returns the number of inserted rows
+func processJob(ctx context.Context, job job, table *kdvh.Table, cache *cache.Cache, pool *pgxpool.Pool, config *Config, plan *dryrun.Report, manifest *checkpoint.Manifest, tableReport *report.Table) (count int64) {
+	for _, segment := range job.segments {
+		if ctx.Err() != nil {
+			break
+		}
+		count += processSegment(ctx, &job, segment, table, cache, pool, config, plan, manifest, tableReport)
+	}
+	return count
 }
 
-func getStationNumber(station os.DirEntry, stationList []string) (int32, error) {
-	if !station.IsDir() {
-		return 0, errors.New(fmt.Sprintf("%s is not a directory, skipping", station.Name()))
+// Imports a single segment file of the job, recording its outcome in the reports
+// and in the checkpoint manifest. Returns the number of inserted rows
+func processSegment(ctx context.Context, job *job, segment kdvh.Segment, table *kdvh.Table, cache *cache.Cache, pool *pgxpool.Pool, config *Config, plan *dryrun.Report, manifest *checkpoint.Manifest, tableReport *report.Table) int64 {
+	stnr, name := job.stnr, segment.Name()
+	stats := tableReport.NewSeries(stnr, name)
+
+	tableMetrics := metrics.Table{Source: "kdvh", Procedure: "import", Name: tableReport.Name}
+	tableMetrics.StartSeries(stnr)
+
+	if config.DryRun {
+		series := planElement(ctx, job, segment, table, cache, pool, config, stats)
+		if series.SkipReason != "" {
+			stats.SkipSeries(series.SkipReason)
+			tableMetrics.SeriesSkipped(stats.RowsRead)
+		} else {
+			stats.Finish(nil)
+			tableMetrics.SeriesDone(stats.RowsRead, 0, nil)
+		}
+		plan.Add(series)
+		return 0
 	}
 
-	if len(stationList) > 0 && !slices.Contains(stationList, station.Name()) {
-		return 0, errors.New(fmt.Sprintf("Station %v not in the list, skipping", station.Name()))
+	if config.Resume && manifest.IsDone(table.TableName, stnr, name) {
+		if config.Verbose {
+			slog.Info(fmt.Sprintf("[%v - %v - %v]: already imported, skipping", table.TableName, stnr, name))
+		}
+		stats.SkipSeries("already imported")
+		tableMetrics.SeriesSkipped(0)
+		return 0
 	}
 
-	stnr, err := strconv.ParseInt(station.Name(), 10, 32)
-	if err != nil {
-		return 0, errors.New("Error parsing station number:" + err.Error())
+	// Batches committed by a previous run are not imported again
+	progress := manifest.Progress(table.TableName, stnr, name)
+	count, err := importElement(ctx, job, segment, table, cache, pool, config, manifest, progress, stats)
+	if reason := skipReason(err); reason != "" {
+		stats.SkipSeries(reason)
+		tableMetrics.SeriesSkipped(stats.RowsRead)
+	} else {
+		stats.Finish(err)
+		tableMetrics.SeriesDone(stats.RowsRead, stats.RowsInserted, err)
 	}
 
-	return int32(stnr), nil
-}
+	if err != nil {
+		err = manifest.Failed(table.TableName, stnr, name, err)
+	} else {
+		err = manifest.Done(table.TableName, stnr, name, progress.Rows+count)
+	}
 
-func elemcodeIsInvalid(element string) bool {
-	return strings.Contains(element, "KOPI") || slices.Contains(INVALID_ELEMENTS, element)
+	if err != nil {
+		slog.Error("Could not update checkpoint manifest: " + err.Error())
+	}
+	return count
 }
 
-func getElementCode(element os.DirEntry, elementList []string) (string, error) {
-	elemCode := strings.ToUpper(strings.TrimSuffix(element.Name(), ".csv"))
+// Imports a single segment file of the (station, element) series in batches, each committed in its own transaction
+// and recorded in the checkpoint manifest. Returns the number of inserted data rows
+func importElement(ctx context.Context, job *job, segment kdvh.Segment, table *kdvh.Table, cache *cache.Cache, pool *pgxpool.Pool, config *Config, manifest *checkpoint.Manifest, progress checkpoint.Entry, stats *report.Series) (int64, error) {
+	stnr, name := job.stnr, segment.Name()
+	tsInfo, _, err := job.timeseries(ctx, table, cache, pool, false)
+	if err != nil {
+		return 0, err
+	}
 
-	if len(elementList) > 0 && !slices.Contains(elementList, elemCode) {
-		return "", errors.New(fmt.Sprintf("Element %q not in the list, skipping", elemCode))
+	if progress.Until != nil {
+		slog.Info(fmt.Sprintf("%sresuming after batch %d (%v)", tsInfo.Logstr, progress.Batch, progress.Until))
 	}
 
-	if elemcodeIsInvalid(elemCode) {
-		return "", errors.New(fmt.Sprintf("Element %q not set for import, skipping", elemCode))
+	number, committed := progress.Batch, progress.Rows
+	err = readBatches(segment.Filename, tsInfo, table, config, stats, progress.Until, func(obs []kdvh.KdvhObs) error {
+		batch := newBatch(obs, tsInfo, table)
+		counts, err := batch.Import(ctx, config.OnConflict, pool, tsInfo.Logstr)
+		if err != nil {
+			return err
+		}
+
+		number += 1
+		committed += counts.Inserted + counts.Updated + counts.Skipped
+		from, to := obs[0].Obstime, obs[len(obs)-1].Obstime
+
+		stats.RowsConverted += int64(len(obs))
+		stats.RowsInserted += counts.Inserted
+		stats.RowsUpdated += counts.Updated
+		stats.RowsConflicting += counts.Skipped
+
+		flags := report.FlagRows{Inserted: counts.Flags.Inserted, Updated: counts.Flags.Updated, Conflicting: counts.Flags.Skipped}
+		stats.Flags.Add(flags)
+		stats.AddBatch(report.Batch{
+			Number:          number,
+			Rows:            counts.Inserted,
+			RowsUpdated:     counts.Updated,
+			RowsConflicting: counts.Skipped,
+			Flags:           flags,
+			From:            from,
+			To:              to,
+		})
+		return manifest.Batch(table.TableName, stnr, name, number, to, committed)
+	})
+	if err != nil && skipReason(err) == "" {
+		slog.Error(tsInfo.Logstr + err.Error())
 	}
-	return elemCode, nil
+	return stats.RowsInserted, err
 }
 
-// Parses the observations in the CSV file, converts them with the table
-// ConvertFunction and returns three arrays that can be passed to pgx.CopyFromRows
-func parseData(filename string, tsInfo *kdvh.TsInfo, table *kdvh.Table, config *Config) ([][]any, [][]any, [][]any, error) {
-	file, err := os.Open(filename)
+// Same as importElement, but only reports what would be inserted in LARD
+func planElement(ctx context.Context, job *job, segment kdvh.Segment, table *kdvh.Table, cache *cache.Cache, pool *pgxpool.Pool, config *Config, stats *report.Series) *dryrun.Series {
+	series := &dryrun.Series{Table: table.TableName, Station: job.stnr, Series: segment.Name(), Timeseries: dryrun.SKIP}
+
+	tsInfo, exists, err := job.timeseries(ctx, table, cache, pool, true)
 	if err != nil {
-		slog.Warn(err.Error())
-		return nil, nil, nil, err
+		series.SkipReason = skipReason(err)
+		if series.SkipReason == "" {
+			series.SkipReason = err.Error()
+		}
+		return series
 	}
-	defer file.Close()
 
-	scanner := bufio.NewScanner(file)
+	// Nothing is committed, so the observations are converted in place only to count the rows
+	var converted int
+	err = readBatches(segment.Filename, tsInfo, table, config, stats, nil, func(obs []kdvh.KdvhObs) error {
+		for _, o := range obs {
+			if _, _, _, err := table.Convert(&o, tsInfo); err != nil {
+				return err
+			}
+		}
+		converted += len(obs)
+		stats.RowsConverted += int64(len(obs))
+		return nil
+	})
+	if err != nil {
+		series.SkipReason = skipReason(err)
+		if series.SkipReason == "" {
+			series.SkipReason = dryrun.INVALID_INPUT + ": " + err.Error()
+		}
+		return series
+	}
 
-	var rowCount int
-	// Try to infer row count from header
-	if config.HasHeader {
-		scanner.Scan()
-		rowCount, _ = strconv.Atoi(scanner.Text())
+	series.Timeseries = dryrun.CREATE
+	if exists {
+		series.Timeseries = dryrun.REUSE
+	}
+	if tsInfo.Param.IsScalar {
+		series.DataRows = converted
+		series.FlagRows = converted
+	} else {
+		series.TextRows = converted
 	}
+	return series
+}
 
-	data := make([][]any, 0, rowCount)
-	text := make([][]any, 0, rowCount)
-	flag := make([][]any, 0, rowCount)
+// Maps the errors that cause a series to be skipped to the reasons shown in the reports.
+// Returns an empty string for all other errors
+func skipReason(err error) string {
+	switch {
+	case errors.Is(err, cache.NO_METADATA_ERR):
+		return dryrun.NO_METADATA
+	case errors.Is(err, cache.RESTRICTED_ERR):
+		return dryrun.RESTRICTED
+	case errors.Is(err, MAX_IMPORT_YEAR_ERR):
+		return dryrun.PAST_IMPORT
+	case errors.Is(err, NO_ROWS_ERR):
+		return dryrun.NO_ROWS
+	}
+	return ""
+}
 
-	for scanner.Scan() {
-		cols := strings.Split(scanner.Text(), config.Sep)
+func getStationNumber(station os.DirEntry, stationList []string) (int32, error) {
+	if !station.IsDir() {
+		return 0, errors.New(fmt.Sprintf("%s is not a directory, skipping", station.Name()))
+	}
 
-		obsTime, err := time.Parse("2006-01-02_15:04:05", cols[0])
-		if err != nil {
-			return nil, nil, nil, err
-		}
+	if len(stationList) > 0 && !slices.Contains(stationList, station.Name()) {
+		return 0, errors.New(fmt.Sprintf("Station %v not in the list, skipping", station.Name()))
+	}
 
-		// Only import data between KDVH's defined fromtime and totime
-		if tsInfo.Timespan.From != nil && obsTime.Sub(*tsInfo.Timespan.From) < 0 {
-			continue
-		} else if tsInfo.Timespan.To != nil && obsTime.Sub(*tsInfo.Timespan.To) > 0 {
-			break
-		}
+	stnr, err := strconv.ParseInt(station.Name(), 10, 32)
+	if err != nil {
+		return 0, errors.New("Error parsing station number:" + err.Error())
+	}
 
-		if table.MaxImportYearReached(obsTime.Year()) {
-			break
-		}
+	return int32(stnr), nil
+}
 
-		obs := kdvh.KdvhObs{Obstime: obsTime, Data: cols[1], Flags: cols[2]}
-		dataRow, textRow, flagRow, err := table.Convert(&obs, tsInfo)
-		if err != nil {
-			return nil, nil, nil, err
-		}
-		data = append(data, dataRow.ToRow())
-		text = append(text, textRow.ToRow())
-		flag = append(flag, flagRow.ToRow())
+// Checks if the element of the table is never imported, see INVALID_ELEMENTS.
+// Elements with a registered transformation are imported
+func ElemcodeIsInvalid(table, element string) bool {
+	if strings.Contains(element, "KOPI") {
+		return true
 	}
+	return slices.Contains(INVALID_ELEMENTS, element) && kdvh.LookupTransform(table, element) == nil
+}
 
-	if len(data) == 0 {
-		slog.Info(tsInfo.Logstr + "no rows to insert (all obstimes > max import time)")
-		return nil, nil, nil, errors.New("No rows to insert")
+// Checks if the element should be imported. Invalid elements are only imported from the quarantine tree
+func checkElement(elemCode string, elementList []string, table *kdvh.Table) error {
+	if len(elementList) > 0 && !slices.Contains(elementList, elemCode) {
+		return errors.New(fmt.Sprintf("Element %q not in the list, skipping", elemCode))
 	}
 
-	return data, text, flag, nil
+	if ElemcodeIsInvalid(table.TableName, elemCode) && !table.Quarantined {
+		return errors.New(fmt.Sprintf("Element %q not set for import, skipping", elemCode))
+	}
+	return nil
 }
+
+var (
+	NO_ROWS_ERR         error = errors.New("No rows to insert")
+	MAX_IMPORT_YEAR_ERR error = errors.New("No rows to insert, all obstimes are past the max import year")
+)
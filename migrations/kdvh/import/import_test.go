@@ -0,0 +1,91 @@
+package port
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	kdvh "migrate/kdvh/db"
+)
+
+func TestCollectJobs(t *testing.T) {
+	config := &Config{Path: t.TempDir()}
+	table := kdvh.NewTable("T_10MINUTE_DATA", "T_10MINUTE_FLAG", "T_ELEM_OBS").SetWindow(kdvh.YEAR)
+
+	// Year directories are listed out of order on purpose
+	files := []string{"2002/TA.csv", "2000/TA.csv", "2001/TA.csv", "2001/TAN.csv", "2001/KOPI_TA.csv"}
+	for _, file := range files {
+		filename := filepath.Join(config.Path, table.Path, "18700", file)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte("# rows: 0\n"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stations, err := os.ReadDir(filepath.Join(config.Path, table.Path))
+	if err != nil {
+		t.Fatal(err)
+	}
+	jobs := collectJobs(stations, table, config)
+
+	type testCase struct {
+		element  string
+		expected []string
+	}
+
+	cases := []testCase{
+		{"TA", []string{"2000/TA", "2001/TA", "2002/TA"}},
+		{"TAN", []string{"2001/TAN"}},
+	}
+
+	if len(jobs) != len(cases) {
+		t.Fatalf("Got %d jobs, wanted %d", len(jobs), len(cases))
+	}
+
+	for _, c := range cases {
+		t.Log("Testing element:", c.element)
+
+		i := slices.IndexFunc(jobs, func(j job) bool { return j.elemCode == c.element })
+		if i < 0 {
+			t.Errorf("Missing job for %s", c.element)
+			continue
+		}
+
+		var names []string
+		for _, segment := range jobs[i].segments {
+			names = append(names, segment.Name())
+		}
+		if !slices.Equal(names, c.expected) {
+			t.Errorf("Got segments %v, wanted %v", names, c.expected)
+		}
+	}
+}
+
+func TestElemcodeIsInvalid(t *testing.T) {
+	type testCase struct {
+		table    string
+		element  string
+		expected bool
+	}
+
+	cases := []testCase{
+		{"T_VDATA", "TA", false},
+		{"T_VDATA", "KOPI_TA", true},
+		{"T_VDATA", "OT", true},
+		{"T_DIURNAL", "OT", false},
+		{"T_MONTH", "OTN", false},
+		{"T_DIURNAL", "DD06", false},
+		{"T_ADATA", "DD06", true},
+	}
+
+	for _, c := range cases {
+		t.Log("Testing element:", c.table, c.element)
+
+		if result := ElemcodeIsInvalid(c.table, c.element); result != c.expected {
+			t.Errorf("Got %v, wanted %v", result, c.expected)
+		}
+	}
+}
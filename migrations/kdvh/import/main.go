@@ -13,6 +13,8 @@ import (
 	kdvh "migrate/kdvh/db"
 	"migrate/kdvh/import/cache"
 	"migrate/lard"
+	"migrate/metrics"
+	"migrate/report"
 	"migrate/utils"
 )
 
@@ -24,32 +26,87 @@ type Config struct {
 	Stations  []string `arg:"-s" help:"Optional space separated list of stations IDs"`
 	Elements  []string `arg:"-e" help:"Optional space separated list of element codes"`
 	Sep       string   `default:"," help:"Separator character in the dumped files. Needs to be quoted"`
-	HasHeader bool     `help:"Add this flag if the dumped files have a header row"`
+	HasHeader bool     `help:"Deprecated, the row count header of older dumps is detected automatically"`
 	// TODO: this isn't implemented in go-arg
 	// Skip      string   `choice:"data" choice:"flags" help:"Skip import of data or flags"`
-	Reindex bool `help:"Drop PG indices before insertion. Might improve performance"`
+	Reindex            bool                `help:"Drop PG indices before insertion. Might improve performance"`
+	Workers            int                 `arg:"-n,--workers" default:"4" help:"Max number of series imported concurrently, i.e. of concurrent connections to LARD"`
+	Resume             bool                `help:"Skip series marked as completed in the checkpoint manifest of a previous run, and retry the failed ones"`
+	Incremental        bool                `help:"Only import the segments written by incremental dumps since the last run. Implies --resume"`
+	BatchSize          int                 `arg:"--batch-size" default:"100000" help:"Number of rows of a series copied to LARD in each transaction. With 0 each series is imported in a single transaction"`
+	OnConflict         lard.ConflictPolicy `arg:"--on-conflict" help:"Merge rows through a staging table instead of copying them directly. Choices: ['skip', 'overwrite', 'fill-nulls']"`
+	DryRun             bool                `arg:"--dry-run" help:"Parse and convert the dumps, and write a report of what would be imported without modifying LARD"`
+	MetricsAddr        string              `arg:"--metrics-addr" help:"Serve Prometheus metrics at this address (e.g. ':9090')"`
+	IncludeQuarantined bool                `arg:"--include-quarantined" help:"Also import the columns dumped to the '_quarantine' directory with 'kdvh dump --include-quarantined'"`
+	Rules              string              `arg:"--rules" help:"CSV file with the rules used to convert the KDVH flags of some tables. Defaults to the rules embedded at build time, see 'kdvh/db/flag_rules.csv'"`
+	Offsets            string              `arg:"--offsets" help:"CSV file with the offsets of the KDVH product obstimes. Defaults to the offsets embedded at build time, see 'kdvh/db/product_offsets.csv'"`
+	OfflineMetadata    bool                `arg:"--offline-metadata" help:"Read the KDVH timespans from the ELEM snapshots saved by 'kdvh dump' in --path, instead of connecting to the KDVH proxy"`
 }
 
-func (config *Config) Execute() {
+// Checks the arguments that go-arg cannot validate
+func (config *Config) Validate() error {
 	if len(config.Sep) > 1 {
-		fmt.Printf("Error: '--sep' only accepts single-byte characters. Got %s", config.Sep)
-		os.Exit(1)
+		return fmt.Errorf("'--sep' only accepts single-byte characters. Got %s", config.Sep)
+	}
+	return nil
+}
+
+// Imports the selected tables. Returns an error if the import could not start or if some series failed
+func (config *Config) Execute(ctx context.Context) (err error) {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	// Segments that were already imported are recorded in the checkpoint manifest
+	if config.Incremental {
+		config.Resume = true
+	}
+
+	if config.MetricsAddr != "" {
+		if err := metrics.Serve(config.MetricsAddr); err != nil {
+			slog.Error(err.Error())
+			return err
+		}
 	}
 
 	slog.Info("Import started!")
 	database := kdvh.Init()
 
-	// Cache metadata from Stinfosys, KDVH, and local `product_offsets.csv`
-	cache := cache.CacheMetadata(config.Tables, config.Stations, config.Elements, database)
+	rules, err := kdvh.ReadFlagRules(config.Rules)
+	if err == nil {
+		err = database.SetFlagRules(rules)
+	}
+	if err != nil {
+		slog.Error("Could not load flag rules: " + err.Error())
+		return fmt.Errorf("Could not load flag rules: %w", err)
+	}
+	slog.Info("Using flag rules version " + rules.Version)
+
+	var snapshotDir string
+	if config.OfflineMetadata {
+		snapshotDir = config.Path
+	}
+
+	// Cache metadata from Stinfosys, KDVH (or its snapshot), and `product_offsets.csv`
+	cache, err := cache.CacheMetadata(ctx, config.Tables, config.Stations, config.Elements, database, snapshotDir, config.Offsets)
+	if err != nil {
+		slog.Error("Could not cache metadata: " + err.Error())
+		return fmt.Errorf("Could not cache metadata:\n%w", err)
+	}
 
 	// Create connection pool for LARD
-	pool, err := pgxpool.New(context.TODO(), os.Getenv(lard.LARD_ENV_VAR))
+	pool, err := pgxpool.New(ctx, os.Getenv(lard.LARD_ENV_VAR))
 	if err != nil {
 		slog.Error(fmt.Sprint("Could not connect to Lard:", err))
-		return
+		return err
 	}
 	defer pool.Close()
 
+	if config.DryRun {
+		// Nothing is inserted, so there is no need to touch the indices
+		config.Reindex = false
+	}
+
 	if config.Reindex {
 		utils.DropIndices(pool)
 	}
@@ -66,7 +123,22 @@ func (config *Config) Execute() {
 		}
 	}()
 
+	run := report.New("kdvh", "import", config)
+	defer func() {
+		if err := run.Write(run.Filename()); err != nil {
+			slog.Error("Could not write run report: " + err.Error())
+		}
+		if err == nil {
+			err = run.Err()
+		}
+	}()
+
 	for _, table := range database.Tables {
+		if ctx.Err() != nil {
+			fmt.Println("Import interrupted, run the same command with --resume to continue")
+			return nil
+		}
+
 		if len(config.Tables) > 0 && !slices.Contains(config.Tables, table.TableName) {
 			continue
 		}
@@ -79,9 +151,15 @@ func (config *Config) Execute() {
 		}
 
 		utils.SetLogFile(table.TableName, "import")
-		ImportTable(table, cache, pool, config)
+		ImportTable(ctx, table, cache, pool, config, run.NewTable(table.TableName))
+
+		if config.IncludeQuarantined && ctx.Err() == nil {
+			quarantine := table.Quarantine()
+			ImportTable(ctx, quarantine, cache, pool, config, run.NewTable(table.TableName+"_QUARANTINE"))
+		}
 	}
 
 	log.SetOutput(os.Stdout)
 	slog.Info("Import complete!")
+	return nil
 }
@@ -0,0 +1,80 @@
+package list
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+
+	"migrate/kdvh/db"
+	"migrate/lard"
+	"migrate/stinfosys"
+)
+
+// Looks up the LARD labels of the dumped series, mapping KDVH elements to params with Stinfosys
+type labelLookup struct {
+	elements stinfosys.ElemMap
+	pool     *pgxpool.Pool
+}
+
+func newLabelLookup(ctx context.Context) (*labelLookup, error) {
+	conn, err := stinfosys.Connect(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	elements, err := stinfosys.CacheElemMap(ctx, conn)
+	if err != nil {
+		return nil, err
+	}
+
+	pool, err := pgxpool.New(ctx, os.Getenv(lard.LARD_ENV_VAR))
+	if err != nil {
+		return nil, err
+	}
+
+	return &labelLookup{elements, pool}, nil
+}
+
+func (lookup *labelLookup) Close(ctx context.Context) {
+	lookup.pool.Close()
+}
+
+// Returns the number of dumped series of the table that already have a `labels.met` entry,
+// out of the ones that can be mapped to a LARD label
+func (lookup *labelLookup) count(ctx context.Context, table *db.Table, status *dumpStatus) string {
+	if !status.dumped {
+		return "-"
+	}
+
+	var found, mapped int
+	for _, s := range status.series {
+		param, ok := lookup.elements[stinfosys.Key{ElemCode: s.element, TableName: table.TableName}]
+		if !ok {
+			continue
+		}
+		mapped++
+
+		label := lard.Label{
+			StationID: s.station,
+			TypeID:    param.TypeID,
+			ParamID:   param.ParamID,
+			Sensor:    &param.Sensor,
+			Level:     param.Hlevel,
+		}
+
+		_, err := lard.FindTimeseriesID(ctx, &label, lookup.pool)
+		if errors.Is(err, pgx.ErrNoRows) {
+			continue
+		} else if err != nil {
+			return "error: " + err.Error()
+		}
+		found++
+	}
+
+	return fmt.Sprintf("%d/%d", found, mapped)
+}
@@ -1,26 +1,125 @@
 package list
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
 	"slices"
+	"strconv"
+	"strings"
+	"text/tabwriter"
 
 	"migrate/kdvh/db"
 )
 
-type Config struct{}
+type Config struct {
+	Path   string   `arg:"-p" help:"Location of the dumped data. If set, shows the status of the local dumps"`
+	Tables []string `arg:"-t" help:"Optional space separated list of table names"`
+	Lard   bool     `help:"Also count the dumped series that already have a label in LARD. Requires --path, and access to Stinfosys and LARD"`
+}
 
-func (config *Config) Execute() {
-	fmt.Println("Available tables in KDVH:")
+func (config *Config) Execute(ctx context.Context) {
+	if config.Lard && config.Path == "" {
+		fmt.Println("Error: --lard requires --path")
+		return
+	}
 
 	kdvh := db.Init()
 
 	var tables []string
 	for table := range kdvh.Tables {
+		if len(config.Tables) > 0 && !slices.Contains(config.Tables, table) {
+			continue
+		}
 		tables = append(tables, table)
 	}
-
 	slices.Sort(tables)
-	for _, table := range tables {
-		fmt.Println("    -", table)
+
+	var lookup *labelLookup
+	if config.Lard {
+		var err error
+		if lookup, err = newLabelLookup(ctx); err != nil {
+			fmt.Println("Could not connect to LARD or Stinfosys:\n" + err.Error())
+			return
+		}
+		defer lookup.Close(ctx)
+	}
+
+	fmt.Println("Available tables in KDVH:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+
+	header := []string{"TABLE", "SOURCE", "FLAG TABLE", "ELEM TABLE", "DUMP FUNC", "CONVERT FUNC", "IMPORT UNTIL", "IMPORT"}
+	if config.Path != "" {
+		header = append(header, "STATIONS", "FILES", "ROWS", "LAST DUMP")
+	}
+	if config.Lard {
+		header = append(header, "IN LARD")
+	}
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	for _, name := range tables {
+		table := kdvh.Tables[name]
+		row := []string{
+			table.TableName,
+			table.ConnEnvVar,
+			orDash(table.FlagTableName),
+			orDash(table.ElemTableName),
+			funcName(table.DumpFn),
+			convertName(table),
+			orDash(importYear(table)),
+			strconv.FormatBool(table.ShouldImport()),
+		}
+
+		if config.Path != "" {
+			status, err := readDumpStatus(filepath.Join(config.Path, table.Path))
+			if err != nil {
+				row = append(row, "error: "+err.Error())
+				fmt.Fprintln(w, strings.Join(row, "\t"))
+				continue
+			}
+			row = append(row, status.columns()...)
+
+			if config.Lard {
+				row = append(row, lookup.count(ctx, table, status))
+			}
+		}
+
+		fmt.Fprintln(w, strings.Join(row, "\t"))
+	}
+	w.Flush()
+}
+
+// Name of the function without the package path
+func funcName(fn any) string {
+	if reflect.ValueOf(fn).IsNil() {
+		return "-"
+	}
+
+	name := runtime.FuncForPC(reflect.ValueOf(fn).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+// Name of the ConvertFunction, or version of the flag rules it is built from
+func convertName(table *db.Table) string {
+	if table.FlagRules != nil {
+		return "flag rules v" + table.FlagRules.Version
+	}
+	return funcName(table.Convert)
+}
+
+func importYear(table *db.Table) string {
+	if !table.ShouldImport() {
+		return ""
+	}
+	return strconv.Itoa(table.ImportYear())
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
 	}
+	return s
 }
@@ -0,0 +1,131 @@
+package list
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"migrate/kdvh/db"
+	"migrate/manifest"
+)
+
+// Status of the local dump of a KDVH table
+type dumpStatus struct {
+	dumped   bool
+	stations int
+	files    int
+	rows     int64
+	lastDump time.Time
+	series   []series // Dumped (station, element) pairs
+}
+
+type series struct {
+	station int32
+	element string
+}
+
+// Collects the status of the dumped table stored in `dir`.
+// The row counts and dump times are taken from the dump manifest if possible,
+// otherwise the files are read.
+func readDumpStatus(dir string) (*dumpStatus, error) {
+	var status dumpStatus
+
+	stations, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return &status, nil
+	} else if err != nil {
+		return nil, err
+	}
+	status.dumped = true
+
+	entries, err := manifest.Load(dir)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	for _, station := range stations {
+		stnr, err := strconv.ParseInt(station.Name(), 10, 32)
+		if !station.IsDir() || err != nil {
+			continue
+		}
+
+		segments, err := db.ListSegments(filepath.Join(dir, station.Name()))
+		if err != nil {
+			return nil, err
+		}
+		if len(segments) == 0 {
+			continue
+		}
+
+		status.stations++
+		seen := make(map[string]bool)
+		for _, segment := range segments {
+			status.files++
+			if !seen[segment.Element] {
+				seen[segment.Element] = true
+				status.series = append(status.series, series{int32(stnr), segment.Element})
+			}
+
+			if err := status.addFile(dir, segment.Filename, entries); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return &status, nil
+}
+
+func (status *dumpStatus) addFile(dir, filename string, entries map[string]manifest.Entry) error {
+	rel, err := filepath.Rel(dir, filename)
+	if err != nil {
+		return err
+	}
+
+	if entry, ok := entries[rel]; ok {
+		status.rows += entry.Rows
+		status.lastDump = latest(status.lastDump, entry.Time)
+		return nil
+	}
+
+	// Older dumps without manifest
+	rows, err := db.CountRows(filename)
+	if err != nil {
+		return fmt.Errorf("%s: %w", rel, err)
+	}
+	info, err := os.Stat(filename)
+	if err != nil {
+		return err
+	}
+
+	status.rows += rows
+	status.lastDump = latest(status.lastDump, info.ModTime().UTC())
+	return nil
+}
+
+func latest(a, b time.Time) time.Time {
+	if b.After(a) {
+		return b
+	}
+	return a
+}
+
+func (status *dumpStatus) columns() []string {
+	if !status.dumped {
+		return []string{"-", "-", "-", "not dumped"}
+	}
+
+	var lastDump string
+	if !status.lastDump.IsZero() {
+		lastDump = status.lastDump.Format(time.DateTime)
+	}
+
+	return []string{
+		strconv.Itoa(status.stations),
+		strconv.Itoa(status.files),
+		strconv.FormatInt(status.rows, 10),
+		orDash(lastDump),
+	}
+}
@@ -0,0 +1,41 @@
+package list
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReadDumpStatus(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"18700/TA.csv":      "2001-07-01_09:00:00,12.9,70000\n# rows: 1\n",
+		"18700/TA.1.csv":    "2001-07-01_10:00:00,13.1,70000\n# rows: 1\n",
+		"18700/TAX.csv":     "2\n2001-07-01_09:00:00,15.0,70000\n2001-07-01_10:00:00,15.2,70000\n",
+		"50540/2001/RR.csv": "2001-07-01_09:00:00,0.4,70000\n# rows: 1\n",
+		"elem_metadata.csv": "stnr,elem_code,fdato,tdato,flag_table_name\n",
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), os.ModePerm); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	status, err := readDumpStatus(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if status.stations != 2 || status.files != 4 || status.rows != 5 || len(status.series) != 3 {
+		t.Errorf("Got %+v, wanted 2 stations, 4 files, 5 rows, and 3 series", status)
+	}
+
+	status, err = readDumpStatus(filepath.Join(dir, "T_ADATA_combined"))
+	if err != nil || status.dumped {
+		t.Errorf("Got (%+v, %v), wanted table that was not dumped", status, err)
+	}
+}
@@ -1,6 +1,7 @@
 package kdvh
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -9,26 +10,44 @@ import (
 	"migrate/kdvh/dump"
 	port "migrate/kdvh/import"
 	"migrate/kdvh/list"
+	"migrate/kdvh/offsets"
+	"migrate/kdvh/plan"
+	"migrate/kdvh/rules"
+	"migrate/kdvh/verify"
 )
 
 // Command line arguments for KDVH migrations
 type Cmd struct {
-	Dump   *dump.Config `arg:"subcommand" help:"Dump tables from KDVH to CSV"`
-	Import *port.Config `arg:"subcommand" help:"Import CSV file dumped from KDVH"`
-	List   *list.Config `arg:"subcommand" help:"List available KDVH tables"`
+	Dump    *dump.Config    `arg:"subcommand" help:"Dump tables from KDVH to CSV"`
+	Import  *port.Config    `arg:"subcommand" help:"Import CSV file dumped from KDVH"`
+	List    *list.Config    `arg:"subcommand" help:"List available KDVH tables"`
+	Plan    *plan.Config    `arg:"subcommand" help:"Map the dumped KDVH series to LARD labels and flag problems"`
+	Offsets *offsets.Config `arg:"subcommand" help:"Check the offsets of the KDVH product obstimes"`
+	Rules   *rules.Config   `arg:"subcommand" help:"Manage the rules used to convert the KDVH flags"`
+	Verify  *verify.Config  `arg:"subcommand:verify-dump" help:"Check the dumped files against the dump manifests"`
 }
 
-func (c *Cmd) Execute(parser *arg.Parser) {
+// Runs the selected subcommand. Dump, import and verify-dump errors are returned, so that main exits with a non-zero status
+func (c *Cmd) Execute(ctx context.Context, parser *arg.Parser) error {
 	switch {
 	case c.Dump != nil:
-		c.Dump.Execute()
+		return c.Dump.Execute(ctx)
 	case c.Import != nil:
-		c.Import.Execute()
+		return c.Import.Execute(ctx)
 	case c.List != nil:
-		c.List.Execute()
+		c.List.Execute(ctx)
+	case c.Plan != nil:
+		c.Plan.Execute(ctx)
+	case c.Offsets != nil:
+		c.Offsets.Execute(ctx, parser)
+	case c.Rules != nil:
+		c.Rules.Execute(ctx, parser)
+	case c.Verify != nil:
+		return c.Verify.Execute(ctx)
 	default:
 		fmt.Println("Error: passing a subcommand is required.")
 		fmt.Println()
 		parser.WriteHelpForSubcommand(os.Stdout, "kdvh")
 	}
+	return nil
 }
@@ -0,0 +1,108 @@
+package offsets
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"text/tabwriter"
+
+	"migrate/kdvh/db"
+	"migrate/stinfosys"
+)
+
+type CheckConfig struct {
+	Offsets string `arg:"--offsets" help:"CSV file with the offsets to check. Defaults to the offsets embedded at build time"`
+}
+
+// Problems reported for a row of the offsets file
+const (
+	DUPLICATED       = "duplicate of line %d"
+	INVALID_PERIOD   = "invalid period, %s"
+	MISSING_ELEM_MAP = "missing from elem_map_cfnames_param"
+	PARAMID_MISMATCH = "paramid %d, but elem_map_cfnames_param has %d"
+)
+
+type problem struct {
+	Line    int
+	Table   string
+	Element string
+	Problem string
+}
+
+// Reads the offsets and reports the rows that are duplicated, have unparsable periods,
+// or do not match `elem_map_cfnames_param` in Stinfosys
+func (config *CheckConfig) Execute(ctx context.Context) {
+	rows, err := db.ReadOffsetRows(config.Offsets)
+	if err != nil {
+		slog.Error(err.Error())
+		fmt.Println("Could not read offsets: " + err.Error())
+		return
+	}
+
+	// The checks that do not need Stinfosys are still run if it cannot be reached
+	var elements stinfosys.ElemMap
+	conn, err := stinfosys.Connect(ctx)
+	if err == nil {
+		defer conn.Close(context.WithoutCancel(ctx))
+		elements, err = stinfosys.CacheElemMap(ctx, conn)
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		fmt.Println("Could not read elem_map_cfnames_param, skipping the Stinfosys checks: " + err.Error())
+	}
+
+	printProblems(os.Stdout, checkOffsets(rows, elements), len(rows))
+}
+
+// Checks each row of the offsets file. The Stinfosys checks are skipped if `elements` is nil
+func checkOffsets(rows []db.OffsetRow, elements stinfosys.ElemMap) []problem {
+	var problems []problem
+	lines := make(map[stinfosys.Key]int)
+
+	for i, row := range rows {
+		line := db.OffsetLine(i)
+		report := func(format string, args ...any) {
+			problems = append(problems, problem{line, row.TableName, row.ElemCode, fmt.Sprintf(format, args...)})
+		}
+
+		key := stinfosys.Key{ElemCode: row.ElemCode, TableName: row.TableName}
+		if first, ok := lines[key]; ok {
+			report(DUPLICATED, first)
+		} else {
+			lines[key] = line
+		}
+
+		if _, err := row.Offset(); err != nil {
+			report(INVALID_PERIOD, err)
+		}
+
+		if elements == nil {
+			continue
+		}
+
+		param, ok := elements[key]
+		if !ok {
+			report(MISSING_ELEM_MAP)
+		} else if param.ParamID != row.ParamID {
+			report(PARAMID_MISMATCH, row.ParamID, param.ParamID)
+		}
+	}
+	return problems
+}
+
+func printProblems(w io.Writer, problems []problem, checked int) {
+	if len(problems) == 0 {
+		fmt.Fprintf(w, "Checked %d offsets, no problems found\n", checked)
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "LINE\tTABLE\tELEMENT\tPROBLEM")
+	for _, p := range problems {
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", p.Line, p.Table, p.Element, p.Problem)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "Checked %d offsets, found %d problems\n", checked, len(problems))
+}
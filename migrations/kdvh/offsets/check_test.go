@@ -0,0 +1,61 @@
+package offsets
+
+import (
+	"slices"
+	"testing"
+
+	"migrate/kdvh/db"
+	"migrate/stinfosys"
+)
+
+func TestCheckOffsets(t *testing.T) {
+	rows := []db.OffsetRow{
+		{TableName: "T_DIURNAL", ElemCode: "EE", ParamID: 129, FromtimeOffset: "PT6H"},
+		{TableName: "T_DIURNAL", ElemCode: "FF2M", ParamID: 3044, FromtimeOffset: "-PT1H", Timespan: "P1D"},
+		{TableName: "T_DIURNAL", ElemCode: "EE", ParamID: 129, FromtimeOffset: "PT6H"},
+		{TableName: "T_DIURNAL", ElemCode: "EM", ParamID: 8, Timespan: "1D"},
+		{TableName: "T_MONTH", ElemCode: "TAM", ParamID: 211},
+	}
+
+	elements := stinfosys.ElemMap{
+		{ElemCode: "EE", TableName: "T_DIURNAL"}:   {ParamID: 129},
+		{ElemCode: "FF2M", TableName: "T_DIURNAL"}: {ParamID: 3044},
+		{ElemCode: "EM", TableName: "T_DIURNAL"}:   {ParamID: 7},
+	}
+
+	type testCase struct {
+		name     string
+		elements stinfosys.ElemMap
+		expected []int // Lines with problems
+	}
+
+	cases := []testCase{
+		{"with Stinfosys", elements, []int{4, 5, 5, 6}},
+		{"without Stinfosys", nil, []int{4, 5}},
+	}
+
+	for _, c := range cases {
+		t.Log("Testing offsets:", c.name)
+
+		var lines []int
+		for _, p := range checkOffsets(rows, c.elements) {
+			lines = append(lines, p.Line)
+		}
+		if !slices.Equal(lines, c.expected) {
+			t.Errorf("Got problems on lines %v, wanted %v", lines, c.expected)
+		}
+	}
+}
+
+func TestEmbeddedOffsets(t *testing.T) {
+	rows, err := db.ReadOffsetRows("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) == 0 {
+		t.Fatal("No embedded offsets")
+	}
+	if problems := checkOffsets(rows, nil); len(problems) > 0 {
+		t.Errorf("Got problems in the embedded offsets: %+v", problems)
+	}
+}
@@ -0,0 +1,25 @@
+package offsets
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/alexflint/go-arg"
+)
+
+// Command line arguments to manage the offsets of the KDVH products, see `kdvh/db/product_offsets.csv`
+type Config struct {
+	Check *CheckConfig `arg:"subcommand" help:"Check the product offsets against Stinfosys and report invalid rows"`
+}
+
+func (c *Config) Execute(ctx context.Context, parser *arg.Parser) {
+	switch {
+	case c.Check != nil:
+		c.Check.Execute(ctx)
+	default:
+		fmt.Println("Error: passing a subcommand is required.")
+		fmt.Println()
+		parser.WriteHelpForSubcommand(os.Stdout, "kdvh", "offsets")
+	}
+}
@@ -0,0 +1,242 @@
+package plan
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocarina/gocsv"
+
+	kdvh "migrate/kdvh/db"
+	port "migrate/kdvh/import"
+	"migrate/kdvh/import/cache"
+	"migrate/stinfosys"
+)
+
+type Config struct {
+	Path     string   `arg:"-p" default:"./dumps/kdvh" help:"Location of the dumped data"`
+	Tables   []string `arg:"-t" help:"Optional space separated list of table names"`
+	Stations []string `arg:"-s" help:"Optional space separated list of stations IDs"`
+	Elements []string `arg:"-e" help:"Optional space separated list of element codes"`
+	Output   string   `arg:"-o" default:"kdvh_plan.csv" help:"Name of the output CSV file"`
+	Offline  bool     `arg:"--offline-metadata" help:"Read the KDVH timespans from the ELEM snapshots saved by 'kdvh dump' in --path, instead of connecting to the KDVH proxy"`
+	Offsets  string   `arg:"--offsets" help:"CSV file with the offsets of the KDVH product obstimes. Defaults to the embedded 'product_offsets.csv'"`
+}
+
+// Problems flagged in the plan
+const (
+	MISSING_ELEM_MAP = "missing from elem_map_cfnames_param"
+	INVALID_ELEMENT  = "skipped by INVALID_ELEMENTS"
+	COLLISION        = "same LARD label as"
+)
+
+// Mapping of a dumped KDVH series to a LARD label
+type Row struct {
+	Table     string `csv:"table"`
+	Station   int32  `csv:"stnr"`
+	Element   string `csv:"elem_code"`
+	StationID int32  `csv:"station_id"`
+	ParamID   string `csv:"param_id"`
+	TypeID    string `csv:"type_id"`
+	Level     string `csv:"lvl"`
+	Sensor    string `csv:"sensor"`
+	Fromtime  string `csv:"fromtime"` // From the KDVH ELEM table
+	Totime    string `csv:"totime"`
+	Offset    string `csv:"offset"` // From `product_offsets.csv`
+	Problems  string `csv:"problems"`
+
+	problems []string
+	label    string // Key used to find collisions
+}
+
+func (config *Config) Execute(ctx context.Context) {
+	database := kdvh.Init()
+
+	// Elements are uppercase in the cache
+	for i, e := range config.Elements {
+		config.Elements[i] = strings.ToUpper(e)
+	}
+
+	var snapshotDir string
+	if config.Offline {
+		snapshotDir = config.Path
+	}
+
+	cache, err := cache.CacheMetadata(ctx, config.Tables, config.Stations, config.Elements, database, snapshotDir, config.Offsets)
+	if err != nil {
+		fmt.Println("Could not cache metadata:\n" + err.Error())
+		return
+	}
+
+	rows, err := config.collectRows(database, cache)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	flagCollisions(rows)
+
+	if err := writeRows(rows, config.Output); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	summary := make(map[string]int)
+	for _, row := range rows {
+		for _, problem := range row.problems {
+			kind, _, _ := strings.Cut(problem, " (")
+			if strings.HasPrefix(kind, COLLISION) {
+				kind = COLLISION + " another series"
+			}
+			summary[kind] += 1
+		}
+	}
+
+	fmt.Printf("%d series mapped, written to %q\n", len(rows), config.Output)
+	kinds := make([]string, 0, len(summary))
+	for kind := range summary {
+		kinds = append(kinds, kind)
+	}
+	slices.Sort(kinds)
+	for _, kind := range kinds {
+		fmt.Printf("    - %d series %s\n", summary[kind], kind)
+	}
+}
+
+// Walks the dump directory and maps each (table, station, element) file to its label
+func (config *Config) collectRows(database *kdvh.KDVH, cache *cache.Cache) ([]*Row, error) {
+	var rows []*Row
+	for _, table := range database.Tables {
+		if len(config.Tables) > 0 && !slices.Contains(config.Tables, table.TableName) {
+			continue
+		}
+
+		stations, err := os.ReadDir(filepath.Join(config.Path, table.Path))
+		if err != nil {
+			// Table was not dumped
+			slog.Info(err.Error())
+			continue
+		}
+
+		for _, station := range stations {
+			if !station.IsDir() || len(config.Stations) > 0 && !slices.Contains(config.Stations, station.Name()) {
+				continue
+			}
+
+			stnr, err := strconv.ParseInt(station.Name(), 10, 32)
+			if err != nil {
+				continue
+			}
+
+			segments, err := kdvh.ListSegments(filepath.Join(config.Path, table.Path, station.Name()))
+			if err != nil {
+				return nil, err
+			}
+
+			// All the segments of an element (time windows and incremental dumps) map to the same label
+			var elements []string
+			for _, segment := range segments {
+				element := segment.Element
+				if slices.Contains(elements, element) {
+					continue
+				}
+				elements = append(elements, element)
+
+				if len(config.Elements) > 0 && !slices.Contains(config.Elements, element) {
+					continue
+				}
+
+				rows = append(rows, newRow(table.TableName, int32(stnr), element, cache))
+			}
+		}
+	}
+
+	slices.SortFunc(rows, func(a, b *Row) int {
+		return cmp.Or(
+			cmp.Compare(a.Table, b.Table),
+			cmp.Compare(a.Station, b.Station),
+			cmp.Compare(a.Element, b.Element),
+		)
+	})
+	return rows, nil
+}
+
+func newRow(table string, stnr int32, element string, metadata *cache.Cache) *Row {
+	row := &Row{Table: table, Station: stnr, Element: element, StationID: stnr}
+
+	if port.ElemcodeIsInvalid(table, element) {
+		row.problems = append(row.problems, INVALID_ELEMENT)
+	}
+
+	key := stinfosys.Key{ElemCode: element, TableName: table}
+	if offset, ok := metadata.Offsets[key]; ok {
+		row.Offset = offset.String()
+	}
+
+	span := metadata.Timespans[cache.NewKDVHKey(element, table, stnr)]
+	row.Fromtime = formatTime(span.From)
+	row.Totime = formatTime(span.To)
+
+	param, ok := metadata.Elements[key]
+	if !ok {
+		row.problems = append(row.problems, MISSING_ELEM_MAP)
+		return row
+	}
+
+	row.ParamID = fmt.Sprint(param.ParamID)
+	row.TypeID = fmt.Sprint(param.TypeID)
+	row.Sensor = fmt.Sprint(param.Sensor)
+	if param.Hlevel != nil {
+		row.Level = fmt.Sprint(*param.Hlevel)
+	}
+	row.label = strings.Join([]string{fmt.Sprint(stnr), row.ParamID, row.TypeID, row.Level, row.Sensor}, "/")
+
+	return row
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return ""
+	}
+	return t.Format(time.DateTime)
+}
+
+// Flags the series that would be imported into the same LARD label from different tables
+func flagCollisions(rows []*Row) {
+	labels := make(map[string][]*Row)
+	for _, row := range rows {
+		if row.label != "" {
+			labels[row.label] = append(labels[row.label], row)
+		}
+	}
+
+	for _, group := range labels {
+		for _, row := range group {
+			for _, other := range group {
+				if other.Table != row.Table {
+					row.problems = append(row.problems, fmt.Sprintf("%s %s (%s)", COLLISION, other.Table, other.Element))
+				}
+			}
+		}
+	}
+}
+
+func writeRows(rows []*Row, filename string) error {
+	for _, row := range rows {
+		row.Problems = strings.Join(row.problems, "; ")
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return gocsv.Marshal(rows, file)
+}
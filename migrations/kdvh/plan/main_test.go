@@ -0,0 +1,48 @@
+package plan
+
+import (
+	"slices"
+	"testing"
+
+	"migrate/kdvh/import/cache"
+	"migrate/stinfosys"
+)
+
+func TestNewRowAndCollisions(t *testing.T) {
+	param := stinfosys.Param{TypeID: 501, ParamID: 211}
+	metadata := &cache.Cache{
+		Elements: stinfosys.ElemMap{
+			{ElemCode: "TA", TableName: "T_ADATA"}:     param,
+			{ElemCode: "TA", TableName: "T_MDATA"}:     param,
+			{ElemCode: "TAM", TableName: "T_MONTH"}:    {TypeID: 312, ParamID: 1},
+			{ElemCode: "TYPEID", TableName: "T_ADATA"}: {TypeID: 501, ParamID: 0},
+		},
+	}
+
+	rows := []*Row{
+		newRow("T_ADATA", 18700, "TA", metadata),
+		newRow("T_MDATA", 18700, "TA", metadata),
+		newRow("T_MONTH", 18700, "TAM", metadata),
+		newRow("T_ADATA", 18700, "FF", metadata),
+		newRow("T_ADATA", 18700, "TYPEID", metadata),
+	}
+	flagCollisions(rows)
+
+	expected := [][]string{
+		{COLLISION + " T_MDATA (TA)"},
+		{COLLISION + " T_ADATA (TA)"},
+		nil,
+		{MISSING_ELEM_MAP},
+		{INVALID_ELEMENT},
+	}
+
+	for i, row := range rows {
+		if !slices.Equal(row.problems, expected[i]) {
+			t.Errorf("%s - %s: got %v, wanted %v", row.Table, row.Element, row.problems, expected[i])
+		}
+	}
+
+	if rows[0].ParamID != "211" || rows[0].TypeID != "501" || rows[0].Sensor != "0" || rows[0].Level != "" {
+		t.Errorf("Unexpected label: %+v", rows[0])
+	}
+}
@@ -0,0 +1,175 @@
+package rules
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"text/tabwriter"
+
+	"migrate/kdvh/db"
+)
+
+type CheckConfig struct {
+	Path   string   `arg:"-p" default:"./dumps/kdvh" help:"Location of the dumped data"`
+	Tables []string `arg:"-t" help:"Optional space separated list of table names. By default all the tables with rules are checked"`
+	Rules  string   `arg:"--rules" help:"CSV file with the flag rules to check. Defaults to the rules embedded at build time"`
+	Sep    string   `default:"," help:"Separator character in the dumped files. Needs to be quoted"`
+}
+
+// KDVH flag that is only matched by the fallback rule of the table
+type uncoveredFlag struct {
+	Table  string
+	Flag   string
+	Value  db.ValueState
+	Rows   int64
+	Series int // Number of dumped files where the flag was found
+}
+
+// Reads the dumps of the tables with flag rules, and lists the flags that do not have their own rule
+func (config *CheckConfig) Execute(ctx context.Context) {
+	kdvh := db.Init()
+	rules, err := db.ReadFlagRules(config.Rules)
+	if err == nil {
+		err = kdvh.SetFlagRules(rules)
+	}
+	if err != nil {
+		slog.Error(err.Error())
+		fmt.Println(err)
+		return
+	}
+	fmt.Printf("Checking flag rules version %s\n", rules.Version)
+
+	var uncovered []uncoveredFlag
+	for _, name := range rules.Tables() {
+		if len(config.Tables) > 0 && !slices.Contains(config.Tables, name) {
+			continue
+		}
+
+		if ctx.Err() != nil {
+			fmt.Println("Check interrupted")
+			return
+		}
+
+		flags, err := checkTable(ctx, filepath.Join(config.Path, kdvh.Tables[name].Path), name, rules, config.Sep)
+		if err != nil {
+			slog.Error(fmt.Sprintf("%s: %s", name, err))
+			fmt.Printf("%s: %s\n", name, err)
+			continue
+		}
+		uncovered = append(uncovered, flags...)
+	}
+
+	printUncovered(os.Stdout, uncovered)
+}
+
+// Counts the flags in the dumped files of the table that are not covered by the rules,
+// sorted by number of rows. Tables that were not dumped are skipped
+func checkTable(ctx context.Context, dir, table string, rules *db.FlagRules, sep string) ([]uncoveredFlag, error) {
+	stations, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	counts := make(map[seenFlag]*uncoveredFlag)
+
+	for _, station := range stations {
+		if _, err := strconv.ParseInt(station.Name(), 10, 32); !station.IsDir() || err != nil {
+			continue
+		}
+
+		segments, err := db.ListSegments(filepath.Join(dir, station.Name()))
+		if err != nil {
+			return nil, err
+		}
+
+		for _, segment := range segments {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+
+			seen, err := readFlags(segment.Filename, sep)
+			if err != nil {
+				return nil, err
+			}
+
+			for flag, rows := range seen {
+				if rules.Covers(table, flag.flag, flag.value == db.PRESENT) {
+					continue
+				}
+
+				if counts[flag] == nil {
+					counts[flag] = &uncoveredFlag{Table: table, Flag: flag.flag, Value: flag.value}
+				}
+				counts[flag].Rows += rows
+				counts[flag].Series += 1
+			}
+		}
+	}
+
+	uncovered := make([]uncoveredFlag, 0, len(counts))
+	for _, flag := range counts {
+		uncovered = append(uncovered, *flag)
+	}
+	slices.SortFunc(uncovered, func(a, b uncoveredFlag) int {
+		return cmp.Or(cmp.Compare(b.Rows, a.Rows), cmp.Compare(a.Flag, b.Flag), cmp.Compare(a.Value, b.Value))
+	})
+	return uncovered, nil
+}
+
+// Flag and value state of a dumped observation
+type seenFlag struct {
+	flag  string
+	value db.ValueState
+}
+
+// Counts the rows of the dumped file for each flag and value state
+func readFlags(filename, sep string) (map[seenFlag]int64, error) {
+	reader, err := db.OpenObsReader(filename, sep)
+	if err != nil {
+		return nil, err
+	}
+	defer reader.Close()
+
+	seen := make(map[seenFlag]int64)
+	for reader.Next() {
+		obs, err := reader.Obs()
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+
+		value := db.MISSING
+		if obs.HasValue() {
+			value = db.PRESENT
+		}
+		seen[seenFlag{obs.Flags, value}] += 1
+	}
+
+	if err := reader.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return seen, nil
+}
+
+func printUncovered(w io.Writer, uncovered []uncoveredFlag) {
+	if len(uncovered) == 0 {
+		fmt.Fprintln(w, "All the flags in the dumps are covered by a rule")
+		return
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "TABLE\tFLAG\tVALUE\tROWS\tFILES")
+	for _, flag := range uncovered {
+		fmt.Fprintf(tw, "%s\t%q\t%s\t%d\t%d\n", flag.Table, flag.Flag, flag.Value, flag.Rows, flag.Series)
+	}
+	tw.Flush()
+	fmt.Fprintf(w, "Found %d flags only covered by the fallback rules\n", len(uncovered))
+}
@@ -0,0 +1,38 @@
+package rules
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"migrate/kdvh/db"
+)
+
+func TestCheckTable(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"18700/TA.csv":  "2001-07-01_00:00:00,,70381\n2001-07-01_01:00:00,,55555\n2001-07-01_02:00:00,1.0,55555\n# rows: 3\n",
+		"18700/TAN.csv": "2001-07-01_00:00:00,,55555\n2001-07-01_01:00:00,,70000\n# rows: 2\n",
+	}
+	for name, content := range files {
+		filename := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	uncovered, err := checkTable(context.Background(), dir, "T_EDATA", db.DEFAULT_FLAG_RULES, ",")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Present values of T_EDATA only have the fallback rule, so they are always covered
+	expected := uncoveredFlag{Table: "T_EDATA", Flag: "55555", Value: db.MISSING, Rows: 2, Series: 2}
+	if len(uncovered) != 1 || uncovered[0] != expected {
+		t.Errorf("Got %+v, wanted [%+v]", uncovered, expected)
+	}
+}
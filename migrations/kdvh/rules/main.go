@@ -0,0 +1,25 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/alexflint/go-arg"
+)
+
+// Command line arguments to manage the KDVH flag rules, see `kdvh/db/flag_rules.csv`
+type Config struct {
+	Check *CheckConfig `arg:"subcommand" help:"Report the KDVH flags found in the dumps that are not covered by a rule"`
+}
+
+func (c *Config) Execute(ctx context.Context, parser *arg.Parser) {
+	switch {
+	case c.Check != nil:
+		c.Check.Execute(ctx)
+	default:
+		fmt.Println("Error: passing a subcommand is required.")
+		fmt.Println()
+		parser.WriteHelpForSubcommand(os.Stdout, "kdvh", "rules")
+	}
+}
@@ -0,0 +1,62 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"migrate/kdvh/db"
+	"migrate/manifest"
+)
+
+type Config struct {
+	Path   string   `arg:"-p" default:"./dumps/kdvh" help:"Location of the dumped data"`
+	Tables []string `arg:"-t" help:"Optional space separated list of table names"`
+}
+
+// Checks the dumped files of each table against the dump manifest.
+// Returns an error if any problem was found, so that the command exits with a non-zero status
+func (config *Config) Execute(ctx context.Context) error {
+	kdvh := db.Init()
+
+	var tables []string
+	for name := range kdvh.Tables {
+		if len(config.Tables) == 0 || slices.Contains(config.Tables, name) {
+			tables = append(tables, name)
+		}
+	}
+	slices.Sort(tables)
+
+	var problems int
+	for _, name := range tables {
+		if ctx.Err() != nil {
+			fmt.Println("Verification interrupted")
+			return ctx.Err()
+		}
+
+		dir := filepath.Join(config.Path, kdvh.Tables[name].Path)
+		if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		results, checked, err := manifest.Verify(ctx, dir, db.CountRows)
+		if err != nil {
+			slog.Error(fmt.Sprintf("%s: %s", name, err))
+			fmt.Printf("%s: %s\n", name, err)
+			problems++
+			continue
+		}
+
+		manifest.PrintResults(os.Stdout, name, results, checked)
+		problems += len(results)
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%w: found %d problems, fix them before importing", manifest.PROBLEMS_ERR, problems)
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"migrate/manifest"
+)
+
+func TestExecute(t *testing.T) {
+	type testCase struct {
+		name      string
+		untracked bool
+		expected  error
+	}
+
+	cases := []testCase{
+		{"no dumps", false, nil},
+		{"untracked file without manifest", true, manifest.PROBLEMS_ERR},
+	}
+
+	for _, c := range cases {
+		t.Log("Testing", c.name)
+
+		path := t.TempDir()
+		if c.untracked {
+			dir := filepath.Join(path, "T_ADATA_combined", "18700")
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(dir, "TA.csv"), []byte("# rows: 0\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		config := Config{Path: path, Tables: []string{"T_ADATA"}}
+		if err := config.Execute(context.Background()); !errors.Is(err, c.expected) {
+			t.Errorf("Got %v, wanted %v", err, c.expected)
+		}
+	}
+}
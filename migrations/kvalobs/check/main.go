@@ -1,6 +1,7 @@
 package check
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"log"
@@ -16,7 +17,7 @@ type Config struct {
 	TextFilename string `arg:"positional" required:"true" help:"text label file"`
 }
 
-func (c *Config) Execute() {
+func (c *Config) Execute(ctx context.Context) {
 	dataParamids, derr := loadParamids(c.DataFilename)
 	textParamids, terr := loadParamids(c.TextFilename)
 	if derr != nil || terr != nil {
@@ -28,9 +29,18 @@ func (c *Config) Execute() {
 	c.checkDataAndTextParamsOverlap(dataParamids, textParamids)
 
 	fmt.Println("Checking if param IDs in `text_data` match non-scalar parameters in Stinfosys")
-	conn, ctx := stinfosys.Connect()
-	defer conn.Close(ctx)
-	stinfoParams := stinfosys.GetNonScalars(conn)
+	conn, err := stinfosys.Connect(ctx)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	defer conn.Close(context.WithoutCancel(ctx))
+
+	stinfoParams, err := stinfosys.GetNonScalars(ctx, conn)
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
 	c.checkNonScalars(dataParamids, textParamids, stinfoParams)
 }
 
@@ -11,16 +11,17 @@ import (
 var FROMTIME time.Time = time.Date(2006, 01, 01, 00, 00, 00, 00, time.UTC)
 
 type BaseConfig struct {
-	Path     string           `arg:"-p" default:"./dumps" help:"Location the dumped data will be stored in"`
-	FromTime *utils.Timestamp `arg:"--from" help:"Fetch data only starting from this date-only timestamp"`
-	ToTime   *utils.Timestamp `arg:"--to" help:"Fetch data only until this date-only timestamp"`
-	Database string           `arg:"--db" help:"Which database to process, all by default. Choices: ['kvalobs', 'histkvalobs']"`
-	Table    string           `help:"Which table to process, all by default. Choices: ['data', 'text_data']"`
-	Stations []int32          `help:"Optional space separated list of station numbers"`
-	TypeIds  []int32          `help:"Optional space separated list of type IDs"`
-	ParamIds []int32          `help:"Optional space separated list of param IDs"`
-	Sensors  []int32          `help:"Optional space separated list of sensors"`
-	Levels   []int32          `help:"Optional space separated list of levels"`
+	Path        string           `arg:"-p" default:"./dumps" help:"Location the dumped data will be stored in"`
+	FromTime    *utils.Timestamp `arg:"--from" help:"Fetch data only starting from this date-only timestamp"`
+	ToTime      *utils.Timestamp `arg:"--to" help:"Fetch data only until this date-only timestamp"`
+	Database    string           `arg:"--db" help:"Which database to process, all by default. Choices: ['kvalobs', 'histkvalobs']"`
+	Table       string           `help:"Which table to process, all by default. Choices: ['data', 'text_data']"`
+	Stations    []int32          `help:"Optional space separated list of station numbers"`
+	TypeIds     []int32          `help:"Optional space separated list of type IDs"`
+	ParamIds    []int32          `help:"Optional space separated list of param IDs"`
+	Sensors     []int32          `help:"Optional space separated list of sensors"`
+	Levels      []int32          `help:"Optional space separated list of levels"`
+	MetricsAddr string           `arg:"--metrics-addr" help:"Serve Prometheus metrics at this address (e.g. ':9090')"`
 }
 
 func (config *BaseConfig) ShouldProcessLabel(label *Label) bool {
@@ -2,114 +2,171 @@ package db
 
 import (
 	"bufio"
+	"fmt"
+	"io"
 	"log/slog"
 	"migrate/lard"
+	"migrate/report"
 	"migrate/utils"
-	"os"
 	"strconv"
+	"strings"
 
-	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/gocarina/gocsv"
 )
 
 // NOTE:
 // - for both kvalobs and histkvalobs:
 //      - all stinfo non-scalar params that can be found in Kvalobs are stored in `text_data`
 //      - 305, 306, 307, 308 are also in `data` but should be treated as `text_data`
-//          => should always use readDataCSV and lard.InsertData for these
+//          => should always use parseData and lard.InsertData for these
 // - only for histkvalobs
 //      - 2751, 2752, 2753, 2754 are in `text_data` but should be treated as `data`?
 
-func importData(tsid int32, label *Label, filename, logStr string, timespan *utils.TimeSpan, pool *pgxpool.Pool) (int64, error) {
-	file, err := os.Open(filename)
+func importData(tsid int32, label *Label, filename, logStr string, timespan *utils.TimeSpan, stats *report.Series) (*lard.Rows, error) {
+	reader, err := openSeries[DataObs](filename)
 	if err != nil {
 		slog.Error(logStr + err.Error())
-		return 0, err
+		return nil, err
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-
-	// Parse number of rows
-	scanner.Scan()
-	rowCount, _ := strconv.Atoi(scanner.Text())
-
-	// Skip header
-	scanner.Scan()
+	defer reader.Close()
 
 	if label.IsSpecialCloudType() {
-		text, err := parseSpecialCloudType(tsid, rowCount, timespan, scanner)
-		if err != nil {
-			slog.Error(logStr + err.Error())
-			return 0, err
-		}
-
-		count, err := lard.InsertTextData(text, pool, logStr)
+		text, err := parseSpecialCloudType(tsid, reader, timespan, stats)
 		if err != nil {
 			slog.Error(logStr + err.Error())
-			return 0, err
+			return nil, err
 		}
 
-		return count, nil
+		return &lard.Rows{Text: text}, nil
 	}
 
-	data, flags, err := parseDataCSV(tsid, rowCount, timespan, scanner)
-	count, err := lard.InsertData(data, pool, logStr)
+	data, flags, err := parseData(tsid, reader, timespan, stats)
 	if err != nil {
 		slog.Error(logStr + err.Error())
-		return 0, err
+		return nil, err
 	}
 
-	if err := lard.InsertFlags(flags, pool, logStr); err != nil {
-		slog.Error(logStr + err.Error())
-		return 0, err
-	}
-
-	return count, nil
+	return &lard.Rows{Data: data, Flags: flags}, nil
 }
 
-func importText(tsid int32, label *Label, filename, logStr string, timespan *utils.TimeSpan, pool *pgxpool.Pool) (int64, error) {
-	file, err := os.Open(filename)
+func importText(tsid int32, label *Label, filename, logStr string, timespan *utils.TimeSpan, stats *report.Series) (*lard.Rows, error) {
+	reader, err := openSeries[TextObs](filename)
 	if err != nil {
 		slog.Error(logStr + err.Error())
-		return 0, err
+		return nil, err
 	}
-	defer file.Close()
-
-	scanner := bufio.NewScanner(file)
-
-	// Parse number of rows
-	scanner.Scan()
-	rowCount, _ := strconv.Atoi(scanner.Text())
-
-	// Skip header
-	scanner.Scan()
+	defer reader.Close()
 
 	if label.IsMetarCloudType() {
-		data, err := parseMetarCloudType(tsid, rowCount, timespan, scanner)
+		data, err := parseMetarCloudType(tsid, reader, timespan, stats)
 		if err != nil {
 			slog.Error(logStr + err.Error())
-			return 0, err
+			return nil, err
 		}
-		count, err := lard.InsertData(data, pool, logStr)
+
+		return &lard.Rows{Data: data}, nil
+	}
+
+	text, err := parseText(tsid, reader, timespan, stats)
+	if err != nil {
+		slog.Error(logStr + err.Error())
+		return nil, err
+	}
+
+	return &lard.Rows{Text: text}, nil
+}
+
+// Reads the typed observations of a dumped series
+type seriesReader[T DataObs | TextObs] interface {
+	// Number of observations reported by the file
+	RowCount() int
+	// Calls fn for each observation, stopping at the first error
+	Each(fn func(*T) error) error
+	Close() error
+}
+
+// Opens the dumped series, either a (compressed) CSV or a Parquet file
+func openSeries[T DataObs | TextObs](filename string) (seriesReader[T], error) {
+	if utils.IsParquet(filename) {
+		reader, err := utils.OpenParquet[T](filename)
 		if err != nil {
-			slog.Error(logStr + err.Error())
-			return 0, err
+			return nil, err
 		}
+		return &parquetSeries[T]{reader}, nil
+	}
 
-		return count, nil
+	file, err := utils.OpenDecompressed(filename)
+	if err != nil {
+		return nil, err
 	}
 
-	text, err := parseTextCSV(tsid, rowCount, timespan, scanner)
+	// Parse number of rows on the first line, the header is on the second one
+	reader := bufio.NewReader(file)
+	line, err := reader.ReadString('\n')
 	if err != nil {
-		slog.Error(logStr + err.Error())
-		return 0, err
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", filename, err)
 	}
 
-	count, err := lard.InsertTextData(text, pool, logStr)
+	rowCount, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: invalid row count on first line: %w", filename, err)
+	}
+
+	return &csvSeries[T]{reader, file, rowCount}, nil
+}
+
+type csvSeries[T DataObs | TextObs] struct {
+	reader   io.Reader
+	file     io.Closer
+	rowCount int
+}
+
+func (s *csvSeries[T]) RowCount() int {
+	return s.rowCount
+}
+
+// Uses a CSV decoder, since text values can contain commas and span multiple lines
+func (s *csvSeries[T]) Each(fn func(*T) error) error {
+	return gocsv.UnmarshalToCallbackWithError(s.reader, func(obs T) error {
+		return fn(&obs)
+	})
+}
+
+func (s *csvSeries[T]) Close() error {
+	return s.file.Close()
+}
+
+type parquetSeries[T DataObs | TextObs] struct {
+	*utils.ParquetReader[T]
+}
+
+func (s *parquetSeries[T]) RowCount() int {
+	return int(s.NumRows())
+}
+
+// Counts the observations in a dumped file, checking them against the count on the first line
+// of CSV files. Parquet files store the number of rows in their metadata.
+func CountRows(filename string) (int64, error) {
+	if utils.IsParquet(filename) {
+		return utils.CountParquetRows(filename)
+	}
+
+	reader, err := openSeries[TextObs](filename)
 	if err != nil {
-		slog.Error(logStr + err.Error())
 		return 0, err
 	}
+	defer reader.Close()
 
-	return count, nil
+	// Only the timestamps are needed, so text obs can be used to read data obs as well
+	var rows int64
+	if err := reader.Each(func(*TextObs) error { rows++; return nil }); err != nil {
+		return rows, err
+	}
+
+	if rows != int64(reader.RowCount()) {
+		return rows, fmt.Errorf("read %d rows, but the first line reports %d", rows, reader.RowCount())
+	}
+	return rows, nil
 }
@@ -112,7 +112,10 @@ func parseFilenameFields(s *string) (*int32, error) {
 
 // Deserialize filename to LardLabel
 func LabelFromFilename(filename string) (*Label, error) {
-	name := strings.TrimSuffix(filename, ".csv")
+	name, ok := utils.TrimDumpExtension(filename)
+	if !ok {
+		return nil, errors.New("Not a CSV file: " + filename)
+	}
 
 	fields := strings.Split(name, "_")
 	if len(fields) != 5 {
@@ -33,12 +33,12 @@ type StationType struct {
 // Lazily initialized slice of distinct stationids and typeids from the `observations` table
 var UNIQUE_STATIONS_TYPES []*StationType = nil
 
-func initUniqueStationsAndTypeIds(timespan *utils.TimeSpan, pool *pgxpool.Pool) error {
+func initUniqueStationsAndTypeIds(ctx context.Context, timespan *utils.TimeSpan, pool *pgxpool.Pool) error {
 	if UNIQUE_STATIONS_TYPES != nil {
 		return nil
 	}
 
-	rows, err := pool.Query(context.TODO(),
+	rows, err := pool.Query(ctx,
 		`SELECT DISTINCT stationid, typeid FROM observations
             WHERE ($1::timestamp IS NULL OR obstime >= $1)
               AND ($2::timestamp IS NULL OR obstime < $2)
@@ -61,12 +61,12 @@ func initUniqueStationsAndTypeIds(timespan *utils.TimeSpan, pool *pgxpool.Pool)
 	return nil
 }
 
-func dumpDataLabels(timespan *utils.TimeSpan, pool *pgxpool.Pool, maxConn int) ([]*Label, error) {
+func dumpDataLabels(ctx context.Context, timespan *utils.TimeSpan, pool *pgxpool.Pool, maxConn int) ([]*Label, error) {
 	// First query stationid and typeid from observations
 	// Then query paramid, sensor, level from obsdata
 	// This is faster than querying all of them together from data
 	slog.Info("Querying data labels...")
-	if err := initUniqueStationsAndTypeIds(timespan, pool); err != nil {
+	if err := initUniqueStationsAndTypeIds(ctx, timespan, pool); err != nil {
 		slog.Error(err.Error())
 		return nil, err
 	}
@@ -87,7 +87,7 @@ func dumpDataLabels(timespan *utils.TimeSpan, pool *pgxpool.Pool, maxConn int) (
 				<-semaphore
 			}()
 
-			rows, err := pool.Query(context.TODO(), OBSDATA_QUERY, s.stationid, s.typeid, timespan.From, timespan.To)
+			rows, err := pool.Query(ctx, OBSDATA_QUERY, s.stationid, s.typeid, timespan.From, timespan.To)
 			if err != nil {
 				slog.Error(err.Error())
 				return
@@ -114,12 +114,12 @@ func dumpDataLabels(timespan *utils.TimeSpan, pool *pgxpool.Pool, maxConn int) (
 	return labels, nil
 }
 
-func dumpTextLabels(timespan *utils.TimeSpan, pool *pgxpool.Pool, maxConn int) ([]*Label, error) {
+func dumpTextLabels(ctx context.Context, timespan *utils.TimeSpan, pool *pgxpool.Pool, maxConn int) ([]*Label, error) {
 	// First query stationid and typeid from observations
 	// Then query paramid from obstextdata
 	// This is faster than querying all of them together from data
 	slog.Info("Querying text labels...")
-	if err := initUniqueStationsAndTypeIds(timespan, pool); err != nil {
+	if err := initUniqueStationsAndTypeIds(ctx, timespan, pool); err != nil {
 		slog.Error(err.Error())
 		return nil, err
 	}
@@ -140,7 +140,7 @@ func dumpTextLabels(timespan *utils.TimeSpan, pool *pgxpool.Pool, maxConn int) (
 				<-semaphore
 			}()
 
-			rows, err := pool.Query(context.TODO(), OBSTEXTDATA_QUERY, s.stationid, s.typeid, timespan.From, timespan.To)
+			rows, err := pool.Query(ctx, OBSTEXTDATA_QUERY, s.stationid, s.typeid, timespan.From, timespan.To)
 			if err != nil {
 				slog.Error(err.Error())
 				return
@@ -109,22 +109,22 @@ type DataSeries = []*DataObs
 
 // Kvalobs data table observation row
 type DataObs struct {
-	Obstime     time.Time `db:"obstime"`
-	Original    float64   `db:"original"`
-	Tbtime      time.Time `db:"tbtime"`
-	Corrected   float64   `db:"corrected"`
-	Controlinfo *string   `db:"controlinfo"`
-	Useinfo     *string   `db:"useinfo"`
-	Cfailed     *string   `db:"cfailed"`
+	Obstime     time.Time `db:"obstime" parquet:"obstime,timestamp(microsecond)"`
+	Original    float64   `db:"original" parquet:"original"`
+	Tbtime      time.Time `db:"tbtime" parquet:"tbtime,timestamp(microsecond)"`
+	Corrected   float64   `db:"corrected" parquet:"corrected"`
+	Controlinfo *string   `db:"controlinfo" parquet:"controlinfo,optional"`
+	Useinfo     *string   `db:"useinfo" parquet:"useinfo,optional"`
+	Cfailed     *string   `db:"cfailed" parquet:"cfailed,optional"`
 }
 
 type TextSeries = []*TextObs
 
 // Kvalobs text_data table observation row
 type TextObs struct {
-	Obstime  time.Time `db:"obstime"`
-	Original string    `db:"original"`
-	Tbtime   time.Time `db:"tbtime"`
+	Obstime  time.Time `db:"obstime" parquet:"obstime,timestamp(microsecond)"`
+	Original string    `db:"original" parquet:"original"`
+	Tbtime   time.Time `db:"tbtime" parquet:"tbtime,timestamp(microsecond)"`
 }
 
 // Basic Metadata for a Kvalobs database
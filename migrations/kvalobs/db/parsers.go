@@ -0,0 +1,176 @@
+package db
+
+import (
+	"migrate/lard"
+	"migrate/report"
+	"migrate/utils"
+	"slices"
+	"strconv"
+	"time"
+)
+
+func parseData(tsid int32, reader seriesReader[DataObs], timespan *utils.TimeSpan, stats *report.Series) ([][]any, [][]any, error) {
+	data := make([][]any, 0, reader.RowCount())
+	flags := make([][]any, 0, reader.RowCount())
+	filter := timeFilter{timespan: timespan, stats: stats}
+
+	err := reader.Each(func(obs *DataObs) error {
+		if !filter.keep(obs.Obstime) {
+			return nil
+		}
+
+		original := float32(obs.Original)
+		corrected := float32(obs.Corrected)
+
+		// Filter out special values that in Kvalobs stand for null observations
+		var originalPtr, correctedPtr *float32
+		if !slices.Contains(NULL_VALUES, original) {
+			originalPtr = &original
+		}
+		if !slices.Contains(NULL_VALUES, corrected) {
+			correctedPtr = &corrected
+		}
+
+		// Original value is inserted in main data table
+		lardObs := lard.DataObs{
+			Id:      tsid,
+			Obstime: obs.Obstime,
+			Data:    originalPtr,
+		}
+
+		var cfailed *string
+		if obs.Cfailed != nil && *obs.Cfailed != "" {
+			cfailed = obs.Cfailed
+		}
+
+		flag := lard.Flag{
+			Id:          tsid,
+			Obstime:     obs.Obstime,
+			Original:    originalPtr,
+			Corrected:   correctedPtr,
+			Controlinfo: obs.Controlinfo, // Never null, has default value in Kvalobs
+			Useinfo:     obs.Useinfo,     // Never null, has default value in Kvalobs
+			Cfailed:     cfailed,
+		}
+
+		data = append(data, lardObs.ToRow())
+		flags = append(flags, flag.ToRow())
+		stats.RowsConverted += 1
+		return nil
+	})
+
+	return data, flags, err
+}
+
+// Text obs are not flagged
+func parseText(tsid int32, reader seriesReader[TextObs], timespan *utils.TimeSpan, stats *report.Series) ([][]any, error) {
+	data := make([][]any, 0, reader.RowCount())
+	filter := timeFilter{timespan: timespan, stats: stats}
+
+	err := reader.Each(func(obs *TextObs) error {
+		if !filter.keep(obs.Obstime) {
+			return nil
+		}
+
+		lardObs := lard.TextObs{
+			Id:      tsid,
+			Obstime: obs.Obstime,
+			Text:    &obs.Original,
+		}
+
+		data = append(data, lardObs.ToRow())
+		stats.RowsConverted += 1
+		return nil
+	})
+
+	return data, err
+}
+
+// Function for paramids 2751, 2752, 2753, 2754 that were stored as text data
+// but should instead be treated as scalars
+// TODO: I'm not sure these params should be scalars given that the other cloud types are not.
+// Should all cloud types be integers or text?
+func parseMetarCloudType(tsid int32, reader seriesReader[TextObs], timespan *utils.TimeSpan, stats *report.Series) ([][]any, error) {
+	data := make([][]any, 0, reader.RowCount())
+	filter := timeFilter{timespan: timespan, stats: stats}
+
+	err := reader.Each(func(obs *TextObs) error {
+		if !filter.keep(obs.Obstime) {
+			return nil
+		}
+
+		val, err := strconv.ParseFloat(obs.Original, 32)
+		if err != nil {
+			return err
+		}
+
+		original := float32(val)
+		lardObs := lard.DataObs{
+			Id:      tsid,
+			Obstime: obs.Obstime,
+			Data:    &original,
+		}
+
+		data = append(data, lardObs.ToRow())
+		stats.RowsConverted += 1
+		return nil
+	})
+
+	// TODO: Original text obs were not flagged, so we don't return a flags?
+	// Or should we return default values?
+	return data, err
+}
+
+// Function for paramids 305, 306, 307, 308 that were stored as scalar data
+// but should be treated as text
+func parseSpecialCloudType(tsid int32, reader seriesReader[DataObs], timespan *utils.TimeSpan, stats *report.Series) ([][]any, error) {
+	data := make([][]any, 0, reader.RowCount())
+	filter := timeFilter{timespan: timespan, stats: stats}
+
+	err := reader.Each(func(obs *DataObs) error {
+		// TODO: should parse everything and return the flags?
+		if !filter.keep(obs.Obstime) {
+			return nil
+		}
+
+		text := strconv.FormatFloat(obs.Original, 'f', -1, 64)
+		lardObs := lard.TextObs{
+			Id:      tsid,
+			Obstime: obs.Obstime,
+			Text:    &text,
+		}
+
+		data = append(data, lardObs.ToRow())
+		stats.RowsConverted += 1
+		return nil
+	})
+
+	return data, err
+}
+
+// Skips the observations outside the timespan. Since the files are sorted by obstime,
+// all the observations following one after `timespan.To` are skipped as well
+type timeFilter struct {
+	timespan *utils.TimeSpan
+	stats    *report.Series
+	done     bool
+}
+
+func (f *timeFilter) keep(obstime time.Time) bool {
+	f.stats.RowsRead += 1
+	if f.done {
+		f.stats.Skip(report.AFTER_TOTIME)
+		return false
+	}
+
+	if f.timespan.From != nil && obstime.Sub(*f.timespan.From) < 0 {
+		f.stats.Skip(report.BEFORE_FROMTIME)
+		return false
+	}
+	if f.timespan.To != nil && obstime.Sub(*f.timespan.To) > 0 {
+		f.done = true
+		f.stats.Skip(report.AFTER_TOTIME)
+		return false
+	}
+	return true
+}
@@ -2,18 +2,23 @@ package db
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"migrate/utils"
 	"os"
 	"path/filepath"
+	"strings"
+	"time"
 
 	"github.com/gocarina/gocsv"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"migrate/manifest"
+	"migrate/utils"
 )
 
-func dumpDataSeries(label *Label, timespan *utils.TimeSpan, path string, pool *pgxpool.Pool) error {
+func dumpDataSeries(ctx context.Context, label *Label, timespan *utils.TimeSpan, path string, opts DumpOptions, pool *pgxpool.Pool) (int64, error) {
 	// NOTE: sensor and level could be NULL, but in reality they have default values
 	query := `SELECT obstime, original, tbtime, corrected, controlinfo, useinfo, cfailed
                 FROM data
@@ -34,7 +39,7 @@ func dumpDataSeries(label *Label, timespan *utils.TimeSpan, path string, pool *p
 	}
 
 	rows, err := pool.Query(
-		context.TODO(),
+		ctx,
 		query,
 		label.StationID,
 		label.TypeID,
@@ -45,18 +50,23 @@ func dumpDataSeries(label *Label, timespan *utils.TimeSpan, path string, pool *p
 		timespan.To,
 	)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	data, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByName[DataObs])
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	var from, to *time.Time
+	if len(data) > 0 {
+		from, to = &data[0].Obstime, &data[len(data)-1].Obstime
 	}
 
-	return writeSeriesCSV(data, path, label)
+	return writeSeries(data, path, label, opts, from, to)
 }
 
-func dumpTextSeries(label *Label, timespan *utils.TimeSpan, path string, pool *pgxpool.Pool) error {
+func dumpTextSeries(ctx context.Context, label *Label, timespan *utils.TimeSpan, path string, opts DumpOptions, pool *pgxpool.Pool) (int64, error) {
 	query := `SELECT obstime, original, tbtime FROM text_data
                 WHERE stationid = $1
                   AND typeid = $2
@@ -66,7 +76,7 @@ func dumpTextSeries(label *Label, timespan *utils.TimeSpan, path string, pool *p
                 ORDER BY obstime`
 
 	rows, err := pool.Query(
-		context.TODO(),
+		ctx,
 		query,
 		label.StationID,
 		label.TypeID,
@@ -75,31 +85,90 @@ func dumpTextSeries(label *Label, timespan *utils.TimeSpan, path string, pool *p
 		timespan.To,
 	)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	data, err := pgx.CollectRows(rows, pgx.RowToAddrOfStructByName[TextObs])
 	if err != nil {
-		return err
+		return 0, err
+	}
+
+	var from, to *time.Time
+	if len(data) > 0 {
+		from, to = &data[0].Obstime, &data[len(data)-1].Obstime
 	}
 
-	return writeSeriesCSV(data, path, label)
+	return writeSeries(data, path, label, opts, from, to)
 }
 
-func writeSeriesCSV[S DataSeries | TextSeries](series S, path string, label *Label) error {
-	filename := filepath.Join(path, label.ToFilename())
-	file, err := os.Create(filename)
+// Options shared by all the dumped series
+type DumpOptions struct {
+	Format      utils.Format
+	Compression utils.Compression
+	Manifest    *manifest.Manifest // Optional, the dumped files are added to it
+}
+
+// Writes the series to file, returning the number of rows written.
+// The file is removed if it could not be written completely or added to the dump manifest
+func writeSeries[T DataObs | TextObs](series []*T, path string, label *Label, opts DumpOptions, from, to *time.Time) (int64, error) {
+	name := strings.TrimSuffix(label.ToFilename(), ".csv")
+	filename := filepath.Join(path, name+opts.Format.Extension(opts.Compression))
+
+	var err error
+	if opts.Format == utils.PARQUET {
+		err = writeSeriesParquet(series, filename, opts.Compression)
+	} else {
+		err = writeSeriesCSV(series, filename, opts.Compression)
+	}
+
+	if err == nil && opts.Manifest != nil {
+		err = opts.Manifest.Add(filename, manifest.Entry{
+			Station: label.StationID,
+			Series:  label.ToFilename(),
+			From:    from,
+			To:      to,
+			Rows:    int64(len(series)),
+		})
+	}
+
 	if err != nil {
 		slog.Error(err.Error())
+		os.Remove(filename)
+		return 0, err
+	}
+
+	return int64(len(series)), nil
+}
+
+func writeSeriesCSV[T DataObs | TextObs](series []*T, filename string, compression utils.Compression) error {
+	file, err := utils.CreateCompressed(filename, compression)
+	if err != nil {
 		return err
 	}
 
 	// Write number of lines on first line, keep headers on 2nd line
 	file.Write([]byte(fmt.Sprintf("%v\n", len(series))))
-	if err = gocsv.Marshal(series, file); err != nil {
-		slog.Error(err.Error())
+	err = gocsv.Marshal(series, file)
+	if closeErr := file.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	return err
+}
+
+// Parquet files store the number of rows and the column names in their metadata
+func writeSeriesParquet[T DataObs | TextObs](series []*T, filename string, compression utils.Compression) error {
+	writer, err := utils.CreateParquet[T](filename, compression)
+	if err != nil {
 		return err
 	}
 
-	return nil
+	for _, obs := range series {
+		if _, err = writer.Write([]T{*obs}); err != nil {
+			break
+		}
+	}
+	if closeErr := writer.Close(); closeErr != nil {
+		err = errors.Join(err, closeErr)
+	}
+	return err
 }
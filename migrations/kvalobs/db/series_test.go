@@ -0,0 +1,61 @@
+package db
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"migrate/utils"
+)
+
+func TestSeriesRoundTrip(t *testing.T) {
+	obstime := time.Date(2023, 6, 1, 12, 0, 0, 0, time.UTC)
+	controlinfo, useinfo, cfailed := "1000000000000000", "9000000000000000", "QC1-1-211"
+	series := DataSeries{
+		{Obstime: obstime, Original: 12.5, Tbtime: obstime.Add(time.Minute), Corrected: 12.4, Controlinfo: &controlinfo, Useinfo: &useinfo, Cfailed: &cfailed},
+		{Obstime: obstime.Add(time.Hour), Original: -32767, Tbtime: obstime.Add(time.Hour), Corrected: 13.1, Controlinfo: &controlinfo, Useinfo: &useinfo},
+	}
+	label := Label{StationID: 18700, ParamID: 211, TypeID: 503}
+
+	for _, opts := range []DumpOptions{{Format: utils.CSV}, {Format: utils.CSV, Compression: utils.ZSTD}, {Format: utils.PARQUET, Compression: utils.ZSTD}} {
+		t.Log("Testing format:", opts.Format, opts.Compression)
+
+		dir := t.TempDir()
+		if _, err := writeSeries(series, dir, &label, opts, nil, nil); err != nil {
+			t.Fatal(err)
+		}
+
+		filename := filepath.Join(dir, "18700_211_503__"+opts.Format.Extension(opts.Compression))
+		reader, err := openSeries[DataObs](filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		var result []DataObs
+		err = reader.Each(func(obs *DataObs) error {
+			result = append(result, *obs)
+			return nil
+		})
+		rowCount := reader.RowCount()
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if rowCount != len(series) || len(result) != len(series) {
+			t.Fatalf("Got %v rows (%v reported), wanted %v", len(result), rowCount, len(series))
+		}
+		for i, obs := range result {
+			if !obs.Obstime.Equal(series[i].Obstime) || obs.Original != series[i].Original || obs.Corrected != series[i].Corrected {
+				t.Errorf("Got %+v, wanted %+v", obs, *series[i])
+			}
+			if obs.Controlinfo == nil || *obs.Controlinfo != controlinfo {
+				t.Errorf("Got controlinfo %v, wanted %v", obs.Controlinfo, controlinfo)
+			}
+		}
+
+		if rows, err := CountRows(filename); err != nil || rows != int64(len(series)) {
+			t.Errorf("Got (%v, %v), wanted %v rows", rows, err, len(series))
+		}
+	}
+}
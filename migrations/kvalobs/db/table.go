@@ -1,6 +1,10 @@
 package db
 
 import (
+	"context"
+
+	"migrate/lard"
+	"migrate/report"
 	"migrate/utils"
 
 	"github.com/jackc/pgx/v5/pgxpool"
@@ -12,14 +16,15 @@ type Table struct {
 	Path       string        // Path of the dumped table
 	DumpLabels LabelDumpFunc // Function that dumps labels from the table
 	DumpSeries ObsDumpFunc   // Function that dumps observations from the table
-	Import     ImportFunc    // Function that parses dumps and ingests observations into LARD
+	Import     ImportFunc    // Function that parses dumps into rows that can be ingested into LARD
 }
 
 // Function used to query labels from kvalobs given an optional timespan
-type LabelDumpFunc func(timespan *utils.TimeSpan, pool *pgxpool.Pool, maxConn int) ([]*Label, error)
+type LabelDumpFunc func(ctx context.Context, timespan *utils.TimeSpan, pool *pgxpool.Pool, maxConn int) ([]*Label, error)
 
-// Function used to query timeseries from kvalobs for a specific label and dump them inside path
-type ObsDumpFunc func(label *Label, timespan *utils.TimeSpan, path string, pool *pgxpool.Pool) error
+// Function used to query timeseries from kvalobs for a specific label and dump them inside path.
+// Returns the number of dumped rows
+type ObsDumpFunc func(ctx context.Context, label *Label, timespan *utils.TimeSpan, path string, opts DumpOptions, pool *pgxpool.Pool) (int64, error)
 
-// Lard Import function
-type ImportFunc func(tsid int32, label *Label, filename, logStr string, timespan *utils.TimeSpan, pool *pgxpool.Pool) (int64, error)
+// Lard Import function, returns the parsed rows for each of the LARD tables
+type ImportFunc func(tsid int32, label *Label, filename, logStr string, timespan *utils.TimeSpan, stats *report.Series) (*lard.Rows, error)
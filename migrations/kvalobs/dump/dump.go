@@ -12,14 +12,17 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 
 	kvalobs "migrate/kvalobs/db"
+	"migrate/manifest"
+	"migrate/metrics"
+	"migrate/report"
 	"migrate/utils"
 )
 
-func getLabels(table *kvalobs.Table, pool *pgxpool.Pool, timespan *utils.TimeSpan, config *Config) (labels []*kvalobs.Label, err error) {
+func getLabels(ctx context.Context, table *kvalobs.Table, pool *pgxpool.Pool, timespan *utils.TimeSpan, config *Config) (labels []*kvalobs.Label, err error) {
 	labelFile := fmt.Sprintf("%s_labels_%s.csv", table.Path, timespan.ToString())
 
 	if _, err := os.Stat(labelFile); err != nil || config.UpdateLabels {
-		labels, err = table.DumpLabels(timespan, pool, config.MaxConn)
+		labels, err = table.DumpLabels(ctx, timespan, pool, config.MaxConn)
 		if err != nil {
 			return nil, err
 		}
@@ -38,26 +41,54 @@ func getStationLabelMap(labels []*kvalobs.Label) map[int32][]*kvalobs.Label {
 	return labelmap
 }
 
-func dumpTable(table *kvalobs.Table, pool *pgxpool.Pool, config *Config) {
+func dumpTable(ctx context.Context, table *kvalobs.Table, pool *pgxpool.Pool, config *Config, tableReport *report.Table) {
 	if !config.LabelsOnly {
 		utils.SetLogFile(table.Path, "dump")
 	}
 	fmt.Printf("Dumping to %q...\n", table.Path)
 	defer fmt.Println(strings.Repeat("- ", 40))
+	defer tableReport.Finish()
+
+	tableMetrics := metrics.Table{Source: "kvalobs", Procedure: "dump", Name: tableReport.Name}
+	tableMetrics.Start()
+	defer tableMetrics.Finish()
 
 	timespan := config.TimeSpan()
-	labels, err := getLabels(table, pool, timespan, config)
-	if err != nil || config.LabelsOnly {
+	labels, err := getLabels(ctx, table, pool, timespan, config)
+	if err != nil {
+		tableReport.Fail(err)
+		return
+	} else if config.LabelsOnly {
 		return
 	}
 
 	stationMap := getStationLabelMap(labels)
 
+	if err := os.MkdirAll(table.Path, os.ModePerm); err != nil {
+		slog.Error(err.Error())
+		tableReport.Fail(err)
+		return
+	}
+
+	dumpManifest, err := manifest.Open(table.Path, table.Name)
+	if err != nil {
+		slog.Error(err.Error())
+		tableReport.Fail(err)
+		return
+	}
+	defer dumpManifest.Close()
+	opts := kvalobs.DumpOptions{Format: config.Format, Compression: config.Compress, Manifest: dumpManifest}
+
 	// Used to limit connections to the database
 	semaphore := make(chan struct{}, config.MaxConn)
 	var wg sync.WaitGroup
 
 	for station, labels := range stationMap {
+		if ctx.Err() != nil {
+			slog.Warn(fmt.Sprintf("%s: dump interrupted", table.Path))
+			break
+		}
+
 		stationPath := filepath.Join(table.Path, fmt.Sprint(station))
 
 		if !utils.IsEmptyOrContains(config.Stations, station) {
@@ -86,12 +117,21 @@ func dumpTable(table *kvalobs.Table, pool *pgxpool.Pool, config *Config) {
 					<-semaphore
 				}()
 
-				if !config.ShouldProcessLabel(label) {
+				// Series that did not start yet are left for the next run
+				if ctx.Err() != nil || !config.ShouldProcessLabel(label) {
 					return
 				}
 
 				logStr := label.LogStr()
-				if err := table.DumpSeries(label, timespan, stationPath, pool); err != nil {
+				stats := tableReport.NewSeries(label.StationID, label.ToFilename())
+				tableMetrics.StartSeries(label.StationID)
+
+				count, err := table.DumpSeries(ctx, label, timespan, stationPath, opts, pool)
+				stats.RowsRead = count
+				stats.RowsInserted = count
+				stats.Finish(err)
+				tableMetrics.SeriesDone(count, count, err)
+				if err != nil {
 					slog.Info(logStr + err.Error())
 					return
 				}
@@ -103,26 +143,31 @@ func dumpTable(table *kvalobs.Table, pool *pgxpool.Pool, config *Config) {
 	}
 }
 
-func dumpDB(database kvalobs.DB, config *Config) {
-	pool, err := pgxpool.New(context.Background(), os.Getenv(database.ConnEnvVar))
+func dumpDB(ctx context.Context, database kvalobs.DB, config *Config, run *report.Run) error {
+	pool, err := pgxpool.New(ctx, os.Getenv(database.ConnEnvVar))
 	if err != nil {
 		slog.Error(fmt.Sprint("Could not connect to Kvalobs:", err))
-		return
+		return err
 	}
 	defer pool.Close()
 
 	path := filepath.Join(config.Path, database.Name)
 	if err := os.MkdirAll(path, os.ModePerm); err != nil {
 		slog.Error(err.Error())
-		return
+		return err
 	}
 
 	for name, table := range database.Tables {
+		if ctx.Err() != nil {
+			return nil
+		}
+
 		if !utils.IsEmptyOrEqual(config.Table, name) {
 			continue
 		}
 
 		table.Path = filepath.Join(path, table.Name)
-		dumpTable(table, pool, config)
+		dumpTable(ctx, table, pool, config, run.NewTable(filepath.Join(database.Name, table.Name)))
 	}
+	return nil
 }
@@ -1,7 +1,14 @@
 package dump
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+
 	"migrate/kvalobs/db"
+	"migrate/metrics"
+	"migrate/report"
 	"migrate/utils"
 )
 
@@ -11,17 +18,45 @@ import (
 
 type Config struct {
 	db.BaseConfig
-	LabelsOnly   bool `arg:"--labels-only" help:"Only dump labels"`
-	UpdateLabels bool `arg:"--labels-update" help:"Overwrites the label CSV files"`
-	MaxConn      int  `arg:"-n" default:"4" help:"Max number of allowed concurrent connections to Kvalobs"`
+	LabelsOnly   bool              `arg:"--labels-only" help:"Only dump labels"`
+	UpdateLabels bool              `arg:"--labels-update" help:"Overwrites the label CSV files"`
+	Format       utils.Format      `default:"csv" help:"Format of the dumped series. Choices: ['csv', 'parquet']"`
+	Compress     utils.Compression `help:"Compress the dumped series. Parquet files compress their columns instead. Choices: ['gzip', 'zstd']"`
+	MaxConn      int               `arg:"-n" default:"4" help:"Max number of allowed concurrent connections to Kvalobs"`
 }
 
-func (config *Config) Execute() {
+// Dumps the selected databases. Returns an error if a database could not be dumped or if some series failed
+func (config *Config) Execute(ctx context.Context) (err error) {
+	if config.MetricsAddr != "" {
+		if err := metrics.Serve(config.MetricsAddr); err != nil {
+			slog.Error(err.Error())
+			return err
+		}
+	}
+
+	run := report.New("kvalobs", "dump", config)
+	defer func() {
+		if err := run.Write(run.Filename()); err != nil {
+			slog.Error("Could not write run report: " + err.Error())
+		}
+		if err == nil {
+			err = run.Err()
+		}
+	}()
+
+	var errs []error
 	dbs := db.InitDBs()
 	for name, db := range dbs {
 		if !utils.IsEmptyOrEqual(config.Database, name) {
 			continue
 		}
-		dumpDB(db, config)
+		if err := dumpDB(ctx, db, config, run); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	if ctx.Err() != nil {
+		fmt.Println("Dump interrupted")
 	}
+	return errors.Join(errs...)
 }
@@ -3,6 +3,8 @@ package cache
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"fmt"
 	"log/slog"
 	"os"
 	"time"
@@ -22,15 +24,25 @@ type Cache struct {
 	// Params  stinfosys.ScalarMap // Don't need them
 }
 
-func New(kvalobs db.DB) *Cache {
-	conn, ctx := stinfosys.Connect()
-	defer conn.Close(ctx)
+// Caches the metadata needed for import of Kvalobs tables.
+// Both sources are queried even if one of them fails, and the returned error
+// joins a `utils.SourceError` for each source that broke.
+func New(ctx context.Context, kvalobs db.DB) (*Cache, error) {
+	var permits stinfosys.PermitMaps
 
-	permits := stinfosys.NewPermitTables(conn)
+	conn, stinfoErr := stinfosys.Connect(ctx)
+	if stinfoErr == nil {
+		defer conn.Close(context.WithoutCancel(ctx))
+		permits, stinfoErr = stinfosys.NewPermitTables(ctx, conn)
+	}
 	// timeseries :=
 
-	timespans := cacheKvalobsTimeseriesTimespans(kvalobs)
-	return &Cache{Permits: permits, Meta: timespans}
+	timespans, kvalobsErr := cacheKvalobsTimeseriesTimespans(ctx, kvalobs)
+
+	if err := errors.Join(stinfoErr, kvalobsErr); err != nil {
+		return nil, err
+	}
+	return &Cache{Permits: permits, Meta: timespans}, nil
 }
 
 func (c *Cache) GetSeriesTimespan(label *db.Label) (utils.TimeSpan, error) {
@@ -66,27 +78,27 @@ type MetaKey struct {
 }
 
 // Query kvalobs `station_metadata` table that stores timeseries timespans
-func cacheKvalobsTimeseriesTimespans(kvalobs db.DB) KvalobsTimespanMap {
+func cacheKvalobsTimeseriesTimespans(ctx context.Context, kvalobs db.DB) (KvalobsTimespanMap, error) {
 	cache := make(KvalobsTimespanMap)
 
 	slog.Info("Connecting to Kvalobs to cache metadata")
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	connCtx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
-	conn, err := pgx.Connect(ctx, os.Getenv(kvalobs.ConnEnvVar))
+	conn, err := pgx.Connect(connCtx, os.Getenv(kvalobs.ConnEnvVar))
 	if err != nil {
-		slog.Error("Could not connect to Kvalobs. Make sure to be connected to the VPN. " + err.Error())
-		os.Exit(1)
+		err = fmt.Errorf("could not connect, make sure to be connected to the VPN - %w", err)
+		return nil, utils.NewSourceError(utils.KVALOBS, err)
 	}
-	defer conn.Close(ctx)
+	defer conn.Close(context.WithoutCancel(ctx))
 
 	query := `SELECT stationid, paramid, fromtime, totime FROM station_metadata`
 
-	rows, err := conn.Query(context.TODO(), query)
+	rows, err := conn.Query(ctx, query)
 	if err != nil {
-		slog.Error(err.Error())
-		os.Exit(1)
+		return nil, utils.NewSourceError(utils.KVALOBS, err)
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var key MetaKey
@@ -99,17 +111,15 @@ func cacheKvalobsTimeseriesTimespans(kvalobs db.DB) KvalobsTimespanMap {
 			&timespan.To,
 		)
 		if err != nil {
-			slog.Error(err.Error())
-			os.Exit(1)
+			return nil, utils.NewSourceError(utils.KVALOBS, err)
 		}
 
 		cache[key] = timespan
 	}
 
 	if rows.Err() != nil {
-		slog.Error(rows.Err().Error())
-		os.Exit(1)
+		return nil, utils.NewSourceError(utils.KVALOBS, rows.Err())
 	}
 
-	return cache
+	return cache, nil
 }
@@ -1,36 +1,79 @@
 package port
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
 	"os"
 	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/jackc/pgx/v5/pgxpool"
 
+	"migrate/checkpoint"
+	"migrate/dryrun"
 	kvalobs "migrate/kvalobs/db"
 	"migrate/kvalobs/import/cache"
 	"migrate/lard"
+	"migrate/metrics"
+	"migrate/report"
 	"migrate/utils"
 )
 
-func ImportTable(table *kvalobs.Table, cache *cache.Cache, pool *pgxpool.Pool, config *Config) (int64, error) {
+var RESTRICTED_ERR error = errors.New("Restricted data")
+
+func ImportTable(ctx context.Context, table *kvalobs.Table, cache *cache.Cache, pool *pgxpool.Pool, config *Config, tableReport *report.Table) (int64, error) {
 	fmt.Printf("Importing from %q...\n", table.Path)
 	defer fmt.Println(strings.Repeat("- ", 40))
+	defer tableReport.Finish()
+
+	tableMetrics := metrics.Table{Source: "kvalobs", Procedure: "import", Name: tableReport.Name}
+	tableMetrics.Start()
+	defer tableMetrics.Finish()
 
+	// Tables that were not dumped are simply skipped
 	stations, err := os.ReadDir(table.Path)
 	if err != nil {
 		slog.Error(err.Error())
+		if !errors.Is(err, fs.ErrNotExist) {
+			tableReport.Fail(err)
+		}
 		return 0, err
 	}
 
-	importTimespan := config.TimeSpan()
+	// The checkpoint manifest is left untouched during dry runs
+	var plan *dryrun.Report
+	var manifest *checkpoint.Manifest
+	if config.DryRun {
+		plan = &dryrun.Report{}
+		defer func() {
+			if err := plan.Write(dryrun.Filename(tableReport.Name)); err != nil {
+				slog.Error("Could not write dry-run report: " + err.Error())
+			}
+		}()
+	} else {
+		manifest, err = checkpoint.Open(filepath.Join(table.Path, checkpoint.FILENAME), config.Resume)
+		if err != nil {
+			slog.Error("Could not open checkpoint manifest: " + err.Error())
+			tableReport.Fail(err)
+			return 0, err
+		}
+		defer manifest.Close()
+	}
+
 	fmt.Printf("Number of stations to import: %d...\n", len(stations))
-	var rowsInserted int64
+	var rowsInserted atomic.Int64
 	for _, station := range stations {
+		if ctx.Err() != nil {
+			slog.Warn(fmt.Sprintf("%s: import interrupted", table.Path))
+			break
+		}
+
 		stnr, err := strconv.ParseInt(station.Name(), 10, 32)
 		if err != nil || !utils.IsEmptyOrContains(config.Stations, int32(stnr)) {
 			continue
@@ -55,6 +98,11 @@ func ImportTable(table *kvalobs.Table, cache *cache.Cache, pool *pgxpool.Pool, c
 					wg.Done()
 				}()
 
+				// Series that did not start yet are left for the next run
+				if ctx.Err() != nil {
+					return
+				}
+
 				label, err := kvalobs.LabelFromFilename(file.Name())
 				if err != nil {
 					slog.Error(err.Error())
@@ -65,59 +113,166 @@ func ImportTable(table *kvalobs.Table, cache *cache.Cache, pool *pgxpool.Pool, c
 					return
 				}
 
-				logStr := label.LogStr()
-				// Check if data for this station/element is restricted
-				if !cache.TimeseriesIsOpen(label.StationID, label.TypeID, label.ParamID) {
-					// TODO: eventually use this to choose which table to use on insert
-					slog.Warn(logStr + "timeseries data is restricted, skipping")
+				filename := filepath.Join(stationDir, file.Name())
+				stats := tableReport.NewSeries(label.StationID, file.Name())
+				tableMetrics.StartSeries(label.StationID)
+
+				if config.DryRun {
+					series := planLabel(ctx, label, filename, table, cache, pool, config, stats)
+					if series.SkipReason != "" {
+						stats.SkipSeries(series.SkipReason)
+						tableMetrics.SeriesSkipped(stats.RowsRead)
+					} else {
+						stats.Finish(nil)
+						tableMetrics.SeriesDone(stats.RowsRead, 0, nil)
+					}
+					plan.Add(series)
 					return
 				}
 
-				tsTimespan, err := cache.GetSeriesTimespan(label)
-				if err != nil {
-					slog.Error(logStr + err.Error())
+				if config.Resume && manifest.IsDone(table.Name, label.StationID, file.Name()) {
+					slog.Info(label.LogStr() + "already imported, skipping")
+					stats.SkipSeries("already imported")
+					tableMetrics.SeriesSkipped(0)
 					return
 				}
 
-				// TODO: figure out where to get fromtime, kvalobs directly? Stinfosys?
-				tsid, err := lard.GetTimeseriesID(label.ToLard(), tsTimespan, pool)
-				if err != nil {
-					slog.Error(logStr + err.Error())
-					return
+				count, err := importLabel(ctx, label, filename, table, cache, pool, config, stats)
+				if errors.Is(err, RESTRICTED_ERR) {
+					stats.SkipSeries(dryrun.RESTRICTED)
+					tableMetrics.SeriesSkipped(stats.RowsRead)
+				} else {
+					stats.Finish(err)
+					tableMetrics.SeriesDone(stats.RowsRead, stats.RowsInserted, err)
 				}
 
-				filename := filepath.Join(stationDir, file.Name())
-				// TODO: it's probably better to dump in different directories
-				// instead of introducing runtime checks
-				count, err := table.Import(tsid, label, filename, logStr, importTimespan, pool)
 				if err != nil {
-					// Logged inside table.Import
-					return
+					err = manifest.Failed(table.Name, label.StationID, file.Name(), err)
+				} else {
+					rowsInserted.Add(count)
+					err = manifest.Done(table.Name, label.StationID, file.Name(), count)
 				}
 
-				rowsInserted += count
+				if err != nil {
+					slog.Error("Could not update checkpoint manifest: " + err.Error())
+				}
 			}()
 		}
 		wg.Wait()
 	}
 
-	outputStr := fmt.Sprintf("%v: %v total rows inserted", table.Path, rowsInserted)
+	inserted := rowsInserted.Load()
+	outputStr := fmt.Sprintf("%v: %v total rows inserted", table.Path, inserted)
 	slog.Info(outputStr)
 	fmt.Println(outputStr)
 
-	return rowsInserted, nil
+	return inserted, nil
 }
 
-func ImportDB(database kvalobs.DB, cache *cache.Cache, pool *pgxpool.Pool, config *Config) {
+// Imports a single label file, returning the number of inserted rows
+func importLabel(ctx context.Context, label *kvalobs.Label, filename string, table *kvalobs.Table, cache *cache.Cache, pool *pgxpool.Pool, config *Config, stats *report.Series) (int64, error) {
+	logStr := label.LogStr()
+	// Check if data for this station/element is restricted
+	if !cache.TimeseriesIsOpen(label.StationID, label.TypeID, label.ParamID) {
+		// TODO: eventually use this to choose which table to use on insert
+		slog.Warn(logStr + "timeseries data is restricted, skipping")
+		return 0, RESTRICTED_ERR
+	}
+
+	tsTimespan, err := cache.GetSeriesTimespan(label)
+	if err != nil {
+		slog.Error(logStr + err.Error())
+		return 0, err
+	}
+
+	// TODO: figure out where to get fromtime, kvalobs directly? Stinfosys?
+	tsid, err := lard.GetTimeseriesID(ctx, label.ToLard(), tsTimespan, pool)
+	if err != nil {
+		slog.Error(logStr + err.Error())
+		return 0, err
+	}
+
+	// TODO: it's probably better to dump in different directories
+	// instead of introducing runtime checks
+	// NOTE: errors are logged inside table.Import
+	rows, err := table.Import(tsid, label, filename, logStr, config.TimeSpan(), stats)
+	if err != nil {
+		return 0, err
+	}
+
+	counts, err := rows.Import(ctx, config.OnConflict, pool, logStr)
+	if err != nil {
+		slog.Error(logStr + err.Error())
+	}
+	stats.RowsInserted = counts.Inserted
+	stats.RowsUpdated = counts.Updated
+	stats.RowsConflicting = counts.Skipped
+	stats.Flags = report.FlagRows{Inserted: counts.Flags.Inserted, Updated: counts.Flags.Updated, Conflicting: counts.Flags.Skipped}
+	return counts.Inserted, err
+}
+
+// Same as importLabel, but only reports what would be inserted in LARD
+func planLabel(ctx context.Context, label *kvalobs.Label, filename string, table *kvalobs.Table, cache *cache.Cache, pool *pgxpool.Pool, config *Config, stats *report.Series) *dryrun.Series {
+	series := &dryrun.Series{
+		Table:      table.Name,
+		Station:    label.StationID,
+		Series:     filepath.Base(filename),
+		Timeseries: dryrun.SKIP,
+	}
+
+	if !cache.TimeseriesIsOpen(label.StationID, label.TypeID, label.ParamID) {
+		series.SkipReason = dryrun.RESTRICTED
+		return series
+	}
+
+	// Only needed to check that metadata can be resolved
+	if _, err := cache.GetSeriesTimespan(label); err != nil {
+		series.SkipReason = dryrun.NO_METADATA
+		return series
+	}
+
+	tsid, action, err := dryrun.TimeseriesAction(func() (int32, error) {
+		return lard.FindTimeseriesID(ctx, label.ToLard(), pool)
+	})
+	if err != nil {
+		series.SkipReason = err.Error()
+		return series
+	}
+	series.Timeseries = action
+
+	rows, err := table.Import(tsid, label, filename, label.LogStr(), config.TimeSpan(), stats)
+	if err != nil {
+		series.Timeseries = dryrun.SKIP
+		series.SkipReason = dryrun.INVALID_INPUT + ": " + err.Error()
+		return series
+	}
+
+	if len(rows.Data) == 0 && len(rows.Text) == 0 {
+		series.Timeseries = dryrun.SKIP
+		series.SkipReason = dryrun.NO_ROWS
+		return series
+	}
+
+	series.DataRows = len(rows.Data)
+	series.TextRows = len(rows.Text)
+	series.FlagRows = len(rows.Flags)
+	return series
+}
+
+func ImportDB(ctx context.Context, database kvalobs.DB, cache *cache.Cache, pool *pgxpool.Pool, config *Config, run *report.Run) {
 	path := filepath.Join(config.Path, database.Name)
 
 	for name, table := range database.Tables {
+		if ctx.Err() != nil {
+			return
+		}
+
 		if !utils.IsEmptyOrEqual(config.Table, name) {
 			continue
 		}
 
 		table.Path = filepath.Join(path, table.Name)
 		utils.SetLogFile(table.Path, "import")
-		ImportTable(table, cache, pool, config)
+		ImportTable(ctx, table, cache, pool, config, run.NewTable(filepath.Join(database.Name, table.Name)))
 	}
 }
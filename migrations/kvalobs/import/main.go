@@ -11,25 +11,48 @@ import (
 	kvalobs "migrate/kvalobs/db"
 	"migrate/kvalobs/import/cache"
 	"migrate/lard"
+	"migrate/metrics"
+	"migrate/report"
 	"migrate/utils"
 )
 
 type Config struct {
 	kvalobs.BaseConfig
-	Reindex bool `help:"Drop PG indices before insertion. Might improve performance"`
+	Reindex    bool                `help:"Drop PG indices before insertion. Might improve performance"`
+	Resume     bool                `help:"Skip series marked as completed in the checkpoint manifest of a previous run, and retry the failed ones"`
+	OnConflict lard.ConflictPolicy `arg:"--on-conflict" help:"Merge rows through a staging table instead of copying them directly. Choices: ['skip', 'overwrite', 'fill-nulls']"`
+	DryRun     bool                `arg:"--dry-run" help:"Parse the dumps and write a report of what would be imported without modifying LARD"`
 }
 
-func (config *Config) Execute() error {
+// Imports the selected databases. Returns an error if the import could not start or if some series failed
+func (config *Config) Execute(ctx context.Context) (err error) {
+	if config.MetricsAddr != "" {
+		if err := metrics.Serve(config.MetricsAddr); err != nil {
+			slog.Error(err.Error())
+			return err
+		}
+	}
+
 	dbs := kvalobs.InitDBs()
 	// Only cache from histkvalobs?
-	cache := cache.New(dbs["histkvalobs"])
+	cache, err := cache.New(ctx, dbs["histkvalobs"])
+	if err != nil {
+		slog.Error("Could not cache metadata: " + err.Error())
+		return err
+	}
 
-	pool, err := pgxpool.New(context.Background(), os.Getenv(lard.LARD_ENV_VAR))
+	pool, err := pgxpool.New(ctx, os.Getenv(lard.LARD_ENV_VAR))
 	if err != nil {
-		slog.Error(fmt.Sprint("Could not connect to Kvalobs:", err))
+		slog.Error(fmt.Sprint("Could not connect to Lard:", err))
+		return err
 	}
 	defer pool.Close()
 
+	if config.DryRun {
+		// Nothing is inserted, so there is no need to touch the indices
+		config.Reindex = false
+	}
+
 	if config.Reindex {
 		utils.DropIndices(pool)
 	}
@@ -46,12 +69,25 @@ func (config *Config) Execute() error {
 		}
 	}()
 
+	run := report.New("kvalobs", "import", config)
+	defer func() {
+		if err := run.Write(run.Filename()); err != nil {
+			slog.Error("Could not write run report: " + err.Error())
+		}
+		if err == nil {
+			err = run.Err()
+		}
+	}()
+
 	for name, db := range dbs {
 		if !utils.IsEmptyOrEqual(config.Database, name) {
 			continue
 		}
-		ImportDB(db, cache, pool, config)
+		ImportDB(ctx, db, cache, pool, config, run)
+	}
 
+	if ctx.Err() != nil {
+		fmt.Println("Import interrupted, run the same command with --resume to continue")
 	}
 
 	return nil
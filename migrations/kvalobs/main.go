@@ -1,6 +1,7 @@
 package kvalobs
 
 import (
+	"context"
 	"fmt"
 	"os"
 
@@ -9,25 +10,31 @@ import (
 	"migrate/kvalobs/check"
 	"migrate/kvalobs/dump"
 	port "migrate/kvalobs/import"
+	"migrate/kvalobs/verify"
 )
 
 type Cmd struct {
-	Dump   *dump.Config  `arg:"subcommand" help:"Dump tables from Kvalobs to CSV"`
-	Import *port.Config  `arg:"subcommand" help:"Import CSV file dumped from Kvalobs"`
-	Check  *check.Config `arg:"subcommand" help:"Performs various checks on kvalobs timeseries"`
+	Dump   *dump.Config   `arg:"subcommand" help:"Dump tables from Kvalobs to CSV"`
+	Import *port.Config   `arg:"subcommand" help:"Import CSV file dumped from Kvalobs"`
+	Check  *check.Config  `arg:"subcommand" help:"Performs various checks on kvalobs timeseries"`
+	Verify *verify.Config `arg:"subcommand:verify-dump" help:"Check the dumped files against the dump manifests"`
 }
 
-func (c *Cmd) Execute(parser *arg.Parser) {
+// Runs the selected subcommand. Dump, import and verify-dump errors are returned, so that main exits with a non-zero status
+func (c *Cmd) Execute(ctx context.Context, parser *arg.Parser) error {
 	switch {
 	case c.Dump != nil:
-		c.Dump.Execute()
+		return c.Dump.Execute(ctx)
 	case c.Import != nil:
-		c.Import.Execute()
+		return c.Import.Execute(ctx)
 	case c.Check != nil:
-		c.Check.Execute()
+		c.Check.Execute(ctx)
+	case c.Verify != nil:
+		return c.Verify.Execute(ctx)
 	default:
 		fmt.Println("Error: passing a subcommand is required.")
 		fmt.Println()
 		parser.WriteHelpForSubcommand(os.Stdout, "kvalobs")
 	}
+	return nil
 }
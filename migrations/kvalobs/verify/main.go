@@ -0,0 +1,67 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"migrate/kvalobs/db"
+	"migrate/manifest"
+	"migrate/utils"
+)
+
+type Config struct {
+	Path     string `arg:"-p" default:"./dumps" help:"Location of the dumped data"`
+	Database string `arg:"--db" help:"Which database to verify, all by default. Choices: ['kvalobs', 'histkvalobs']"`
+	Table    string `help:"Which table to verify, all by default. Choices: ['data', 'text_data']"`
+}
+
+// Checks the dumped files of each table against the dump manifest.
+// Returns an error if any problem was found, so that the command exits with a non-zero status
+func (config *Config) Execute(ctx context.Context) error {
+	var dirs []string
+	for name, database := range db.InitDBs() {
+		if !utils.IsEmptyOrEqual(config.Database, name) {
+			continue
+		}
+		for _, table := range database.Tables {
+			if utils.IsEmptyOrEqual(config.Table, table.Name) {
+				dirs = append(dirs, filepath.Join(database.Name, table.Name))
+			}
+		}
+	}
+	slices.Sort(dirs)
+
+	var problems int
+	for _, name := range dirs {
+		if ctx.Err() != nil {
+			fmt.Println("Verification interrupted")
+			return ctx.Err()
+		}
+
+		dir := filepath.Join(config.Path, name)
+		if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+
+		results, checked, err := manifest.Verify(ctx, dir, db.CountRows)
+		if err != nil {
+			slog.Error(fmt.Sprintf("%s: %s", name, err))
+			fmt.Printf("%s: %s\n", name, err)
+			problems++
+			continue
+		}
+
+		manifest.PrintResults(os.Stdout, name, results, checked)
+		problems += len(results)
+	}
+
+	if problems > 0 {
+		return fmt.Errorf("%w: found %d problems, fix them before importing", manifest.PROBLEMS_ERR, problems)
+	}
+	return nil
+}
@@ -4,18 +4,130 @@ import (
 	"context"
 	"fmt"
 	"log/slog"
+	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
+
+	"migrate/metrics"
 )
 
-func InsertData(ts [][]any, pool *pgxpool.Pool, logStr string) (int64, error) {
-	size := len(ts)
-	count, err := pool.CopyFrom(
-		context.TODO(),
+// Subset of methods shared by `pgxpool.Pool` and `pgx.Tx`
+type DB interface {
+	Begin(ctx context.Context) (pgx.Tx, error)
+	CopyFrom(ctx context.Context, table pgx.Identifier, columns []string, src pgx.CopyFromSource) (int64, error)
+	Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+// Rows of a single LARD table that can be copied with `pgx.CopyFrom`
+type Source interface {
+	pgx.CopyFromSource
+	// Number of rows yielded by the source
+	Len() int
+}
+
+// Source of rows that are already in memory
+type rowSource struct {
+	pgx.CopyFromSource
+	size int
+}
+
+func (s *rowSource) Len() int {
+	return s.size
+}
+
+// Wraps the rows in a Source, returns nil if there are no rows
+func FromRows(rows [][]any) Source {
+	if len(rows) == 0 {
+		return nil
+	}
+	return &rowSource{pgx.CopyFromRows(rows), len(rows)}
+}
+
+// Rows parsed from a dumped series, ready to be copied into LARD
+type Rows struct {
+	Data  [][]any // Rows for `public.data`
+	Text  [][]any // Rows for `public.nonscalar_data`
+	Flags [][]any // Rows for `flags.kvdata`
+}
+
+// Number of rows affected by an import. The embedded counts are the data and non-scalar data rows,
+// i.e. the observations, while the flag rows are counted separately
+type ImportCounts struct {
+	Counts
+	Flags Counts
+}
+
+// Inserts the rows in their respective tables inside a single transaction,
+// so that a failed or cancelled series does not leave partial data behind
+func (r *Rows) Import(ctx context.Context, policy ConflictPolicy, pool *pgxpool.Pool, logStr string) (ImportCounts, error) {
+	batch := Batch{Data: FromRows(r.Data), Text: FromRows(r.Text), Flags: FromRows(r.Flags)}
+	return batch.Import(ctx, policy, pool, logStr)
+}
+
+// Same as Rows, but the rows are read from sources that can produce them lazily
+// while they are copied. Nil sources are skipped
+type Batch struct {
+	Data  Source // Rows for `public.data`
+	Text  Source // Rows for `public.nonscalar_data`
+	Flags Source // Rows for `flags.kvdata`
+}
+
+// Inserts the rows of the batch in their respective tables inside a single transaction
+func (b *Batch) Import(ctx context.Context, policy ConflictPolicy, pool *pgxpool.Pool, logStr string) (ImportCounts, error) {
+	tx, err := pool.Begin(ctx)
+	if err != nil {
+		return ImportCounts{}, err
+	}
+	// The rollback should go through even if the context was cancelled
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	var dataCounts, textCounts, flagCounts Counts
+	if b.Data != nil {
+		start := time.Now()
+		if dataCounts, err = ImportData(ctx, b.Data, policy, tx, logStr); err != nil {
+			return ImportCounts{}, fmt.Errorf("failed data bulk insertion - %w", err)
+		}
+		metrics.CopyDuration.WithLabelValues("data").Observe(time.Since(start).Seconds())
+	}
+
+	if b.Text != nil {
+		start := time.Now()
+		if textCounts, err = ImportTextData(ctx, b.Text, policy, tx, logStr); err != nil {
+			return ImportCounts{}, fmt.Errorf("failed non-scalar data bulk insertion - %w", err)
+		}
+		metrics.CopyDuration.WithLabelValues("nonscalar_data").Observe(time.Since(start).Seconds())
+	}
+
+	if b.Flags != nil {
+		start := time.Now()
+		if flagCounts, err = ImportFlags(ctx, b.Flags, policy, tx, logStr); err != nil {
+			return ImportCounts{}, fmt.Errorf("failed flag bulk insertion - %w", err)
+		}
+		metrics.CopyDuration.WithLabelValues("flags.kvdata").Observe(time.Since(start).Seconds())
+	}
+
+	if err := tx.Commit(ctx); err != nil {
+		return ImportCounts{}, err
+	}
+
+	metrics.LardRowsInserted.WithLabelValues("data").Add(float64(dataCounts.Inserted + dataCounts.Updated))
+	metrics.LardRowsInserted.WithLabelValues("nonscalar_data").Add(float64(textCounts.Inserted + textCounts.Updated))
+	metrics.LardRowsInserted.WithLabelValues("flags.kvdata").Add(float64(flagCounts.Inserted + flagCounts.Updated))
+
+	dataCounts.Add(textCounts)
+	return ImportCounts{Counts: dataCounts, Flags: flagCounts}, nil
+}
+
+func InsertData(ctx context.Context, ts Source, db DB, logStr string) (int64, error) {
+	size := ts.Len()
+	count, err := db.CopyFrom(
+		ctx,
 		pgx.Identifier{"public", "data"},
 		[]string{"timeseries", "obstime", "obsvalue"},
-		pgx.CopyFromRows(ts),
+		ts,
 	)
 	if err != nil {
 		return count, err
@@ -30,13 +142,13 @@ func InsertData(ts [][]any, pool *pgxpool.Pool, logStr string) (int64, error) {
 	return count, nil
 }
 
-func InsertTextData(ts [][]any, pool *pgxpool.Pool, logStr string) (int64, error) {
-	size := len(ts)
-	count, err := pool.CopyFrom(
-		context.TODO(),
+func InsertTextData(ctx context.Context, ts Source, db DB, logStr string) (int64, error) {
+	size := ts.Len()
+	count, err := db.CopyFrom(
+		ctx,
 		pgx.Identifier{"public", "nonscalar_data"},
 		[]string{"timeseries", "obstime", "obsvalue"},
-		pgx.CopyFromRows(ts),
+		ts,
 	)
 	if err != nil {
 		return count, err
@@ -51,16 +163,16 @@ func InsertTextData(ts [][]any, pool *pgxpool.Pool, logStr string) (int64, error
 	return count, nil
 }
 
-func InsertFlags(ts [][]any, pool *pgxpool.Pool, logStr string) error {
-	size := len(ts)
-	count, err := pool.CopyFrom(
-		context.TODO(),
+func InsertFlags(ctx context.Context, ts Source, db DB, logStr string) (int64, error) {
+	size := ts.Len()
+	count, err := db.CopyFrom(
+		ctx,
 		pgx.Identifier{"flags", "kvdata"},
 		[]string{"timeseries", "obstime", "original", "corrected", "controlinfo", "useinfo", "cfailed"},
-		pgx.CopyFromRows(ts),
+		ts,
 	)
 	if err != nil {
-		return err
+		return count, err
 	}
 
 	logStr += fmt.Sprintf("%v/%v flag rows inserted", count, size)
@@ -69,5 +181,5 @@ func InsertFlags(ts [][]any, pool *pgxpool.Pool, logStr string) error {
 	} else {
 		slog.Info(logStr)
 	}
-	return nil
+	return count, nil
 }
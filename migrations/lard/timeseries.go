@@ -2,8 +2,10 @@ package lard
 
 import (
 	"context"
+	"errors"
 	"migrate/utils"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgxpool"
 )
 
@@ -23,10 +25,11 @@ func (l *Label) sensorLevelAreBothZero() bool {
 	return *l.Level == 0 && *l.Sensor == 0
 }
 
-func GetTimeseriesID(label *Label, timespan utils.TimeSpan, pool *pgxpool.Pool) (tsid int32, err error) {
+// Returns the ID of the timeseries matching the label, or `pgx.ErrNoRows` if it does not exist in LARD
+func FindTimeseriesID(ctx context.Context, label *Label, pool *pgxpool.Pool) (tsid int32, err error) {
 	// Query LARD labels table
 	err = pool.QueryRow(
-		context.TODO(),
+		ctx,
 		`SELECT timeseries FROM labels.met
             WHERE station_id = $1
             AND param_id = $2
@@ -36,8 +39,8 @@ func GetTimeseriesID(label *Label, timespan utils.TimeSpan, pool *pgxpool.Pool)
 		label.StationID, label.ParamID, label.TypeID, label.Level, label.Sensor).Scan(&tsid)
 
 	// If timeseries exists, return its ID
-	if err == nil {
-		return tsid, nil
+	if err == nil || !errors.Is(err, pgx.ErrNoRows) {
+		return tsid, err
 	}
 
 	// In KDVH and Kvalobs sensor and level have default values, while in LARD they are NULL
@@ -46,8 +49,8 @@ func GetTimeseriesID(label *Label, timespan utils.TimeSpan, pool *pgxpool.Pool)
 	// FIXME(?): in some cases, level and sensor are marked with (0,0) in Obsinn,
 	// so there might be problems if a timeseries is not present in LARD at the time of importing
 	if label.sensorLevelAreBothZero() {
-		err := pool.QueryRow(
-			context.TODO(),
+		err = pool.QueryRow(
+			ctx,
 			`SELECT timeseries FROM labels.met
                 WHERE station_id = $1
                 AND param_id = $2
@@ -55,21 +58,28 @@ func GetTimeseriesID(label *Label, timespan utils.TimeSpan, pool *pgxpool.Pool)
                 AND lvl IS NULL
                 AND sensor IS NULL`,
 			label.StationID, label.ParamID, label.TypeID).Scan(&tsid)
+	}
+
+	return tsid, err
+}
 
-		if err == nil {
-			return tsid, nil
-		}
+// Returns the ID of the timeseries matching the label, inserting a new timeseries if it does not exist
+func GetTimeseriesID(ctx context.Context, label *Label, timespan utils.TimeSpan, pool *pgxpool.Pool) (tsid int32, err error) {
+	tsid, err = FindTimeseriesID(ctx, label, pool)
+	if err == nil || !errors.Is(err, pgx.ErrNoRows) {
+		return tsid, err
 	}
 
-	// If none of the above worked insert a new timeseries
-	transaction, err := pool.Begin(context.TODO())
+	// Otherwise insert a new timeseries
+	transaction, err := pool.Begin(ctx)
 	if err != nil {
 		return tsid, err
 	}
+	defer transaction.Rollback(context.WithoutCancel(ctx))
 
 	// TODO: should we set `deactivated` to true if `totime` is not NULL?
 	err = transaction.QueryRow(
-		context.TODO(),
+		ctx,
 		`INSERT INTO public.timeseries (fromtime, totime) VALUES ($1, $2) RETURNING id`,
 		timespan.From, timespan.To,
 	).Scan(&tsid)
@@ -78,7 +88,7 @@ func GetTimeseriesID(label *Label, timespan utils.TimeSpan, pool *pgxpool.Pool)
 	}
 
 	_, err = transaction.Exec(
-		context.TODO(),
+		ctx,
 		`INSERT INTO labels.met (timeseries, station_id, param_id, type_id, lvl, sensor)
             VALUES ($1, $2, $3, $4, $5, $6)`,
 		tsid, label.StationID, label.ParamID, label.TypeID, label.Level, label.Sensor)
@@ -86,6 +96,6 @@ func GetTimeseriesID(label *Label, timespan utils.TimeSpan, pool *pgxpool.Pool)
 		return tsid, err
 	}
 
-	err = transaction.Commit(context.TODO())
+	err = transaction.Commit(ctx)
 	return tsid, err
 }
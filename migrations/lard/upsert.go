@@ -0,0 +1,203 @@
+package lard
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/jackc/pgx/v5"
+)
+
+// Defines what happens when an imported row already exists in LARD
+type ConflictPolicy string
+
+const (
+	// Rows are copied directly into the target table and any conflict makes the import fail
+	NO_POLICY ConflictPolicy = ""
+	// Existing rows are left untouched
+	SKIP ConflictPolicy = "skip"
+	// Existing rows are replaced by the imported ones
+	OVERWRITE ConflictPolicy = "overwrite"
+	// Only the NULL columns of existing rows are filled with the imported values
+	FILL_NULLS ConflictPolicy = "fill-nulls"
+)
+
+func (p *ConflictPolicy) UnmarshalText(b []byte) error {
+	switch policy := ConflictPolicy(b); policy {
+	case SKIP, OVERWRITE, FILL_NULLS:
+		*p = policy
+		return nil
+	}
+	return fmt.Errorf("Invalid conflict policy %q. Choices: ['%s', '%s', '%s']", b, SKIP, OVERWRITE, FILL_NULLS)
+}
+
+// Number of rows affected by an upsert
+type Counts struct {
+	Inserted int64
+	Updated  int64
+	Skipped  int64
+}
+
+func (c *Counts) Add(other Counts) {
+	c.Inserted += other.Inserted
+	c.Updated += other.Updated
+	c.Skipped += other.Skipped
+}
+
+func (c Counts) String() string {
+	return fmt.Sprintf("%v inserted, %v updated, %v skipped", c.Inserted, c.Updated, c.Skipped)
+}
+
+// Table where the rows are merged into
+type target struct {
+	table   pgx.Identifier
+	keys    []string
+	columns []string
+}
+
+var (
+	dataTarget = target{
+		pgx.Identifier{"public", "data"},
+		[]string{"timeseries", "obstime"},
+		[]string{"obsvalue"},
+	}
+	textTarget = target{
+		pgx.Identifier{"public", "nonscalar_data"},
+		[]string{"timeseries", "obstime"},
+		[]string{"obsvalue"},
+	}
+	flagTarget = target{
+		pgx.Identifier{"flags", "kvdata"},
+		[]string{"timeseries", "obstime"},
+		[]string{"original", "corrected", "controlinfo", "useinfo", "cfailed"},
+	}
+)
+
+// The following functions insert the rows with a plain COPY if no conflict policy is set,
+// otherwise they merge them into the target table
+func ImportData(ctx context.Context, ts Source, policy ConflictPolicy, db DB, logStr string) (Counts, error) {
+	if policy == NO_POLICY {
+		count, err := InsertData(ctx, ts, db, logStr)
+		return Counts{Inserted: count}, err
+	}
+	return UpsertData(ctx, ts, policy, db, logStr)
+}
+
+func ImportTextData(ctx context.Context, ts Source, policy ConflictPolicy, db DB, logStr string) (Counts, error) {
+	if policy == NO_POLICY {
+		count, err := InsertTextData(ctx, ts, db, logStr)
+		return Counts{Inserted: count}, err
+	}
+	return UpsertTextData(ctx, ts, policy, db, logStr)
+}
+
+func ImportFlags(ctx context.Context, ts Source, policy ConflictPolicy, db DB, logStr string) (Counts, error) {
+	if policy == NO_POLICY {
+		count, err := InsertFlags(ctx, ts, db, logStr)
+		return Counts{Inserted: count}, err
+	}
+	return UpsertFlags(ctx, ts, policy, db, logStr)
+}
+
+func UpsertData(ctx context.Context, ts Source, policy ConflictPolicy, db DB, logStr string) (Counts, error) {
+	return upsert(ctx, ts, dataTarget, policy, db, logStr+"data rows: ")
+}
+
+func UpsertTextData(ctx context.Context, ts Source, policy ConflictPolicy, db DB, logStr string) (Counts, error) {
+	return upsert(ctx, ts, textTarget, policy, db, logStr+"non-scalar data rows: ")
+}
+
+func UpsertFlags(ctx context.Context, ts Source, policy ConflictPolicy, db DB, logStr string) (Counts, error) {
+	return upsert(ctx, ts, flagTarget, policy, db, logStr+"flag rows: ")
+}
+
+// COPYs the rows into a temporary staging table and then merges them into the target table
+// following the given conflict policy. Everything happens inside a single transaction
+// (or a savepoint, if `db` is already a transaction).
+func upsert(ctx context.Context, ts Source, target target, policy ConflictPolicy, db DB, logStr string) (counts Counts, err error) {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return counts, err
+	}
+	defer tx.Rollback(context.WithoutCancel(ctx))
+
+	columns := slices.Concat(target.keys, target.columns)
+	selectColumns := strings.Join(columns, ", ")
+
+	// Use the target table as template, so we don't have to redefine the column types
+	_, err = tx.Exec(
+		ctx,
+		fmt.Sprintf(
+			"CREATE TEMP TABLE staging ON COMMIT DROP AS SELECT %s FROM %s WITH NO DATA",
+			selectColumns,
+			target.table.Sanitize(),
+		),
+	)
+	if err != nil {
+		return counts, err
+	}
+
+	if _, err = tx.CopyFrom(ctx, pgx.Identifier{"staging"}, columns, ts); err != nil {
+		return counts, err
+	}
+
+	// NOTE: `xmax` is zero only for newly inserted rows.
+	// Duplicated keys inside the staging table are removed, otherwise Postgres
+	// refuses to update the same row twice in a single statement.
+	query := fmt.Sprintf(
+		`WITH merged AS (
+            INSERT INTO %[1]s AS t (%[2]s)
+                SELECT DISTINCT ON (%[3]s) %[2]s FROM staging ORDER BY %[3]s
+            ON CONFLICT (%[3]s) %[4]s
+            RETURNING (xmax = 0) AS inserted
+        )
+        SELECT count(*) FILTER (WHERE inserted), count(*) FILTER (WHERE NOT inserted) FROM merged`,
+		target.table.Sanitize(),
+		selectColumns,
+		strings.Join(target.keys, ", "),
+		conflictClause(target.columns, policy),
+	)
+
+	if err = tx.QueryRow(ctx, query).Scan(&counts.Inserted, &counts.Updated); err != nil {
+		return counts, err
+	}
+
+	// Dropped explicitly, since `ON COMMIT DROP` only applies to the outermost transaction
+	if _, err = tx.Exec(ctx, "DROP TABLE staging"); err != nil {
+		return counts, err
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return Counts{}, err
+	}
+
+	counts.Skipped = int64(ts.Len()) - counts.Inserted - counts.Updated
+	slog.Info(logStr + counts.String())
+	return counts, nil
+}
+
+// Builds the `ON CONFLICT` action for the given policy.
+// Rows that would not change are not updated, so they are counted as skipped.
+func conflictClause(columns []string, policy ConflictPolicy) string {
+	set := make([]string, len(columns))
+	where := make([]string, len(columns))
+
+	switch policy {
+	case OVERWRITE:
+		for i, col := range columns {
+			set[i] = fmt.Sprintf("%[1]s = EXCLUDED.%[1]s", col)
+			where[i] = fmt.Sprintf("t.%[1]s IS DISTINCT FROM EXCLUDED.%[1]s", col)
+		}
+	case FILL_NULLS:
+		for i, col := range columns {
+			set[i] = fmt.Sprintf("%[1]s = COALESCE(t.%[1]s, EXCLUDED.%[1]s)", col)
+			where[i] = fmt.Sprintf("(t.%[1]s IS NULL AND EXCLUDED.%[1]s IS NOT NULL)", col)
+		}
+	default:
+		return "DO NOTHING"
+	}
+
+	return fmt.Sprintf("DO UPDATE SET %s WHERE %s", strings.Join(set, ", "), strings.Join(where, " OR "))
+}
@@ -0,0 +1,42 @@
+package lard
+
+import (
+	"testing"
+)
+
+func TestConflictClause(t *testing.T) {
+	type testCase struct {
+		policy   ConflictPolicy
+		expected string
+	}
+
+	cases := []testCase{
+		{SKIP, "DO NOTHING"},
+		{
+			OVERWRITE,
+			"DO UPDATE SET original = EXCLUDED.original, useinfo = EXCLUDED.useinfo " +
+				"WHERE t.original IS DISTINCT FROM EXCLUDED.original OR t.useinfo IS DISTINCT FROM EXCLUDED.useinfo",
+		},
+		{
+			FILL_NULLS,
+			"DO UPDATE SET original = COALESCE(t.original, EXCLUDED.original), useinfo = COALESCE(t.useinfo, EXCLUDED.useinfo) " +
+				"WHERE (t.original IS NULL AND EXCLUDED.original IS NOT NULL) OR (t.useinfo IS NULL AND EXCLUDED.useinfo IS NOT NULL)",
+		},
+	}
+
+	for _, c := range cases {
+		if result := conflictClause([]string{"original", "useinfo"}, c.policy); result != c.expected {
+			t.Errorf("%s: got %q, wanted %q", c.policy, result, c.expected)
+		}
+	}
+}
+
+func TestUnmarshalConflictPolicy(t *testing.T) {
+	var policy ConflictPolicy
+	if err := policy.UnmarshalText([]byte("fill-nulls")); err != nil || policy != FILL_NULLS {
+		t.Errorf("Got (%q, %v), wanted %q", policy, err, FILL_NULLS)
+	}
+	if err := policy.UnmarshalText([]byte("replace")); err == nil {
+		t.Error("Expected error for invalid policy")
+	}
+}
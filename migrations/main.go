@@ -1,20 +1,25 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/alexflint/go-arg"
 	"github.com/joho/godotenv"
 
 	"migrate/kdvh"
 	"migrate/kvalobs"
+	"migrate/runfile"
 )
 
 type CmdArgs struct {
-	KDVH    *kdvh.Cmd    `arg:"subcommand" help:"Perform KDVH migrations"`
-	Kvalobs *kvalobs.Cmd `arg:"subcommand" help:"Perform Kvalobs migrations"`
+	KDVH    *kdvh.Cmd       `arg:"subcommand" help:"Perform KDVH migrations"`
+	Kvalobs *kvalobs.Cmd    `arg:"subcommand" help:"Perform Kvalobs migrations"`
+	Run     *runfile.Config `arg:"subcommand" help:"Run the dump and import steps described in a TOML run file"`
 }
 
 func main() {
@@ -23,6 +28,7 @@ func main() {
 	// The following env variables are required:
 	// 1. Dump
 	//   - kdvh: "KDVH_PROXY_CONN_STRING"
+	//       - optional, for the tables outside the proxy: "KDVH_AVINOR_CONN_STRING", "KDVH_PROJDATA_CONN_STRING"
 	//   - kvalobs: "KVALOBS_CONN_STRING", "HISTKVALOBS_CONN_STRING"
 	//
 	// 2. Import
@@ -37,14 +43,30 @@ func main() {
 	args := CmdArgs{}
 	parser := arg.MustParse(&args)
 
+	// The context is cancelled on the first SIGINT/SIGTERM, so that in-flight series
+	// can finish (or roll back) and the reports are written. A second signal kills the process.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+		fmt.Println("\nInterrupted, waiting for in-flight series to finish. Press Ctrl-C again to force exit")
+	}()
+
 	switch {
 	case args.KDVH != nil:
-		args.KDVH.Execute(parser)
+		err = args.KDVH.Execute(ctx, parser)
 	case args.Kvalobs != nil:
-		args.Kvalobs.Execute(parser)
+		err = args.Kvalobs.Execute(ctx, parser)
+	case args.Run != nil:
+		args.Run.Execute(ctx)
 	default:
 		fmt.Println("Error: passing a subcommand is required.")
 		fmt.Println()
 		parser.WriteHelp(os.Stdout)
 	}
+
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 }
@@ -0,0 +1,149 @@
+package manifest
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Name of the manifest file that is stored next to the dumped files of each table
+const FILENAME string = "dump_manifest.jsonl"
+
+// Line of the manifest file, describing a single dumped file.
+// For KDVH `Series` is the element code, for Kvalobs it is the label filename.
+type Entry struct {
+	File    string     `json:"file"` // Path relative to the manifest directory
+	Table   string     `json:"table"`
+	Station int32      `json:"station"`
+	Series  string     `json:"series"`
+	From    *time.Time `json:"from,omitempty"`
+	To      *time.Time `json:"to,omitempty"`
+	Rows    int64      `json:"rows"`
+	Bytes   int64      `json:"bytes"`
+	SHA256  string     `json:"sha256"`
+	Time    time.Time  `json:"time"`
+}
+
+// Manifest of the files dumped for a table.
+// Entries are appended as soon as a file is written, so files dumped by previous runs
+// (e.g. without `--overwrite`) keep their entry. If the same file appears multiple times,
+// the last entry wins.
+type Manifest struct {
+	mutex   sync.Mutex
+	dir     string
+	table   string
+	file    *os.File
+	entries map[string]Entry
+}
+
+// Opens the manifest of the table in the directory, creating it if needed
+func Open(dir, table string) (*Manifest, error) {
+	entries, err := Load(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		entries = make(map[string]Entry)
+	} else if err != nil {
+		return nil, err
+	}
+
+	file, err := os.OpenFile(filepath.Join(dir, FILENAME), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, err
+	}
+	return &Manifest{dir: dir, table: table, file: file, entries: entries}, nil
+}
+
+// Returns the latest entry of the dumped file
+func (m *Manifest) Lookup(filename string) (Entry, bool) {
+	file, err := filepath.Rel(m.dir, filename)
+	if err != nil {
+		return Entry{}, false
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	entry, ok := m.entries[file]
+	return entry, ok
+}
+
+// Hashes the dumped file and appends its entry to the manifest
+func (m *Manifest) Add(filename string, entry Entry) error {
+	var err error
+	entry.Table = m.table
+	if entry.File, err = filepath.Rel(m.dir, filename); err != nil {
+		return err
+	}
+
+	if entry.Bytes, entry.SHA256, err = HashFile(filename); err != nil {
+		return err
+	}
+	entry.Time = time.Now().UTC()
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.entries[entry.File] = entry
+	_, err = m.file.Write(append(line, '\n'))
+	return err
+}
+
+func (m *Manifest) Close() error {
+	return m.file.Close()
+}
+
+// Returns the size and the SHA-256 of the file
+func HashFile(filename string) (int64, string, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(hash, file)
+	if err != nil {
+		return 0, "", err
+	}
+	return size, hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Reads the manifest in the directory, returning the latest entry of each file
+func Load(dir string) (map[string]Entry, error) {
+	path := filepath.Join(dir, FILENAME)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	entries := make(map[string]Entry)
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			// The last line could be incomplete if the program crashed while writing it,
+			// in which case the file is reported as untracked
+			slog.Warn(fmt.Sprintf("Skipping line %d of dump manifest %q: %s", line, path, err))
+			continue
+		}
+		entries[entry.File] = entry
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
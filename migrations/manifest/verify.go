@@ -0,0 +1,138 @@
+package manifest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"migrate/utils"
+)
+
+// Error returned if the table directory does not contain a manifest
+var NO_MANIFEST_ERR error = errors.New("no dump manifest found, the table might have been dumped by an older version")
+
+// Returned by the verify-dump commands when any file is missing, corrupt or untracked
+var PROBLEMS_ERR error = errors.New("the dumped files do not match the dump manifests")
+
+type Problem string
+
+const (
+	// The file is listed in the manifest but it does not exist
+	MISSING Problem = "MISSING"
+	// The size or the hash of the file do not match the manifest
+	CORRUPT Problem = "CORRUPT"
+	// The rows in the file do not match the count stored in the file or in the manifest
+	ROW_COUNT Problem = "ROW_COUNT"
+	// The file is not listed in the manifest, usually because the dump was interrupted
+	UNTRACKED Problem = "UNTRACKED"
+)
+
+type Result struct {
+	File    string
+	Problem Problem
+	Detail  string
+}
+
+// Counts the rows of a dumped file, checking them against the count stored in the file itself
+type CountFunc func(filename string) (int64, error)
+
+// Checks the dumped files in the directory against its manifest.
+// Returns the problems found and the number of checked files.
+func Verify(ctx context.Context, dir string, count CountFunc) ([]Result, int, error) {
+	entries, err := Load(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, 0, NO_MANIFEST_ERR
+	} else if err != nil {
+		return nil, 0, err
+	}
+
+	files := make([]string, 0, len(entries))
+	for file := range entries {
+		files = append(files, file)
+	}
+	slices.Sort(files)
+
+	var results []Result
+	var checked int
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return results, checked, ctx.Err()
+		}
+
+		checked++
+		if result := verifyEntry(dir, entries[file], count); result != nil {
+			results = append(results, *result)
+		}
+	}
+
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+
+		if _, ok := utils.TrimDumpExtension(d.Name()); d.IsDir() || !ok {
+			return nil
+		}
+
+		// The series are stored in the station directories, the top level only holds metadata
+		if filepath.Dir(path) == filepath.Clean(dir) {
+			return nil
+		}
+
+		file, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		if _, ok := entries[file]; !ok {
+			checked++
+			results = append(results, Result{file, UNTRACKED, "not listed in the manifest"})
+		}
+		return nil
+	})
+
+	return results, checked, err
+}
+
+func verifyEntry(dir string, entry Entry, count CountFunc) *Result {
+	filename := filepath.Join(dir, entry.File)
+
+	size, hash, err := HashFile(filename)
+	if errors.Is(err, os.ErrNotExist) {
+		return &Result{entry.File, MISSING, "file not found"}
+	} else if err != nil {
+		return &Result{entry.File, CORRUPT, err.Error()}
+	}
+
+	if size != entry.Bytes {
+		return &Result{entry.File, CORRUPT, fmt.Sprintf("size is %d bytes, the manifest reports %d", size, entry.Bytes)}
+	}
+	if hash != entry.SHA256 {
+		return &Result{entry.File, CORRUPT, "SHA-256 does not match the manifest"}
+	}
+
+	rows, err := count(filename)
+	if err != nil {
+		return &Result{entry.File, ROW_COUNT, err.Error()}
+	}
+	if rows != entry.Rows {
+		return &Result{entry.File, ROW_COUNT, fmt.Sprintf("file has %d rows, the manifest reports %d", rows, entry.Rows)}
+	}
+
+	return nil
+}
+
+// Prints the problems found in the dump of a table
+func PrintResults(w io.Writer, table string, results []Result, checked int) {
+	fmt.Fprintf(w, "%s: %d files checked, %d problems\n", table, checked, len(results))
+	for _, result := range results {
+		fmt.Fprintf(w, "    %-10s %s: %s\n", result.Problem, result.File, result.Detail)
+	}
+}
@@ -0,0 +1,67 @@
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func countLines(filename string) (int64, error) {
+	content, err := os.ReadFile(filename)
+	return int64(bytes.Count(content, []byte("\n"))), err
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "18700"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	manifest, err := Open(dir, "T_ADATA")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{"TA": "a\nb\n", "TAX": "a\n", "TAN": "a\nb\nc\n"}
+	for series, content := range files {
+		filename := filepath.Join(dir, "18700", series+".csv")
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := manifest.Add(filename, Entry{Station: 18700, Series: series, Rows: int64(len(content) / 2)}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	manifest.Close()
+
+	// Corrupt, remove, and add files after the dump
+	os.WriteFile(filepath.Join(dir, "18700", "TA.csv"), []byte("a\nc\n"), 0644)
+	os.Remove(filepath.Join(dir, "18700", "TAX.csv"))
+	os.WriteFile(filepath.Join(dir, "18700", "RR.csv"), []byte("a\n"), 0644)
+	os.WriteFile(filepath.Join(dir, "elem_metadata.csv"), []byte("a\n"), 0644)
+
+	results, checked, err := Verify(context.Background(), dir, countLines)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]Problem{
+		filepath.Join("18700", "TA.csv"):  CORRUPT,
+		filepath.Join("18700", "TAX.csv"): MISSING,
+		filepath.Join("18700", "RR.csv"):  UNTRACKED,
+	}
+
+	if checked != 4 {
+		t.Errorf("Got %v checked files, wanted 4", checked)
+	}
+	if len(results) != len(expected) {
+		t.Errorf("Got %v problems, wanted %v: %v", len(results), len(expected), results)
+	}
+	for _, result := range results {
+		if expected[result.File] != result.Problem {
+			t.Errorf("Got %v for %q, wanted %v", result.Problem, result.File, expected[result.File])
+		}
+	}
+}
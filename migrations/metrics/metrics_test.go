@@ -0,0 +1,69 @@
+package metrics
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"migrate/utils"
+)
+
+func TestTable(t *testing.T) {
+	table := Table{Source: "kdvh", Procedure: "import", Name: "T_TEST"}
+	table.Start()
+	table.StartSeries(18700)
+	table.SeriesDone(3, 2, nil)
+	table.SeriesDone(1, 0, fmt.Errorf("wrapped - %w", context.Canceled))
+	table.SeriesSkipped(0)
+
+	if value := testutil.ToFloat64(CurrentStation.WithLabelValues("kdvh", "import", "T_TEST")); value != 18700 {
+		t.Errorf("Got current station %v, wanted 18700", value)
+	}
+
+	type testCase struct {
+		status   string
+		expected float64
+	}
+
+	cases := []testCase{{DONE, 1}, {FAILED, 1}, {SKIPPED, 1}}
+	for _, c := range cases {
+		t.Log("Testing status", c.status)
+		if value := testutil.ToFloat64(SeriesProcessed.WithLabelValues("kdvh", "import", "T_TEST", c.status)); value != c.expected {
+			t.Errorf("Got %v series, wanted %v", value, c.expected)
+		}
+	}
+
+	if value := testutil.ToFloat64(RowsRead.WithLabelValues("kdvh", "import", "T_TEST")); value != 4 {
+		t.Errorf("Got %v rows read, wanted 4", value)
+	}
+	if value := testutil.ToFloat64(RowsWritten.WithLabelValues("kdvh", "import", "T_TEST")); value != 2 {
+		t.Errorf("Got %v rows written, wanted 2", value)
+	}
+	if value := testutil.ToFloat64(Errors.WithLabelValues("kdvh", "import", CANCELLED)); value != 1 {
+		t.Errorf("Got %v cancelled errors, wanted 1", value)
+	}
+
+	table.Finish()
+	if count := testutil.CollectAndCount(CurrentTable, "migrate_current_table"); count != 0 {
+		t.Errorf("Expected no current tables, got %d", count)
+	}
+}
+
+func TestCategory(t *testing.T) {
+	cases := []struct {
+		err      error
+		expected string
+	}{
+		{fmt.Errorf("wrapped - %w", context.Canceled), CANCELLED},
+		{utils.NewSourceError(utils.STINFOSYS, fmt.Errorf("broken")), METADATA},
+		{fmt.Errorf("something else"), OTHER},
+	}
+
+	for _, c := range cases {
+		if result := Category(c.err); result != c.expected {
+			t.Errorf("%v: got %q, wanted %q", c.err, result, c.expected)
+		}
+	}
+}
@@ -0,0 +1,143 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"strconv"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+
+	"migrate/utils"
+)
+
+// Metrics shared by the dump and import commands.
+// `source` is "kdvh" or "kvalobs", `procedure` is "dump" or "import"
+var (
+	SeriesProcessed = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "migrate_series_processed_total",
+		Help: "Number of processed series by outcome (done, failed, skipped)",
+	}, []string{"source", "procedure", "table", "status"})
+	RowsRead = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "migrate_rows_read_total",
+		Help: "Number of rows read from the source database or from the dumped files",
+	}, []string{"source", "procedure", "table"})
+	RowsWritten = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "migrate_rows_written_total",
+		Help: "Number of rows written to the dumped files or inserted in LARD",
+	}, []string{"source", "procedure", "table"})
+	Errors = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "migrate_errors_total",
+		Help: "Number of failed series by error category",
+	}, []string{"source", "procedure", "category"})
+	CurrentTable = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "migrate_current_table",
+		Help: "Set to 1 for the tables that are currently being processed",
+	}, []string{"source", "procedure", "table"})
+	CurrentStation = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "migrate_current_station",
+		Help: "Station number of the last series started for the table",
+	}, []string{"source", "procedure", "table"})
+
+	// The following are only updated by imports, `target` is the LARD table
+	LardRowsInserted = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "migrate_lard_rows_inserted_total",
+		Help: "Number of rows inserted (or updated) in each LARD table",
+	}, []string{"target"})
+	CopyDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "migrate_copy_duration_seconds",
+		Help:    "Latency of COPY (or staging table merge) into each LARD table",
+		Buckets: []float64{0.01, 0.05, 0.1, 0.5, 1, 5, 10, 30, 60, 300},
+	}, []string{"target"})
+)
+
+// Outcomes of a series used in `SeriesProcessed`
+const (
+	DONE    = "done"
+	FAILED  = "failed"
+	SKIPPED = "skipped"
+)
+
+// Labels of the metrics of a table being dumped or imported
+type Table struct {
+	Source    string
+	Procedure string
+	Name      string
+}
+
+func (t Table) labels() []string {
+	return []string{t.Source, t.Procedure, t.Name}
+}
+
+// Marks the table as currently being processed
+func (t Table) Start() {
+	CurrentTable.WithLabelValues(t.labels()...).Set(1)
+}
+
+// Removes the table from the ones currently being processed
+func (t Table) Finish() {
+	CurrentTable.DeleteLabelValues(t.labels()...)
+}
+
+// Records the station of the series that was just started
+func (t Table) StartSeries(station int32) {
+	CurrentStation.WithLabelValues(t.labels()...).Set(float64(station))
+}
+
+// Records a processed series, with the category of the error if it failed
+func (t Table) SeriesDone(rowsRead, rowsWritten int64, err error) {
+	status := DONE
+	if err != nil {
+		status = FAILED
+		Errors.WithLabelValues(t.Source, t.Procedure, Category(err)).Inc()
+	}
+	t.observe(status, rowsRead, rowsWritten)
+}
+
+// Records a series that was skipped as a whole
+func (t Table) SeriesSkipped(rowsRead int64) {
+	t.observe(SKIPPED, rowsRead, 0)
+}
+
+func (t Table) observe(status string, rowsRead, rowsWritten int64) {
+	SeriesProcessed.WithLabelValues(t.Source, t.Procedure, t.Name, status).Inc()
+	RowsRead.WithLabelValues(t.labels()...).Add(float64(rowsRead))
+	RowsWritten.WithLabelValues(t.labels()...).Add(float64(rowsWritten))
+}
+
+// Error categories
+const (
+	CANCELLED = "cancelled"
+	DATABASE  = "database"
+	METADATA  = "metadata"
+	IO        = "io"
+	PARSE     = "parse"
+	OTHER     = "other"
+)
+
+// Returns the category of the error used in `Errors`
+func Category(err error) string {
+	var pgErr *pgconn.PgError
+	var connErr *pgconn.ConnectError
+	var sourceErr *utils.SourceError
+	var pathErr *fs.PathError
+	var numErr *strconv.NumError
+	var timeErr *time.ParseError
+
+	switch {
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return CANCELLED
+	case errors.As(err, &sourceErr):
+		return METADATA
+	case errors.As(err, &pgErr), errors.As(err, &connErr), pgconn.Timeout(err):
+		return DATABASE
+	case errors.As(err, &pathErr):
+		return IO
+	case errors.As(err, &numErr), errors.As(err, &timeErr):
+		return PARSE
+	}
+	return OTHER
+}
@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Starts serving the metrics at `http://<addr>/metrics` in the background.
+// Returns an error if the address cannot be listened on
+func Serve(addr string) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("could not serve metrics - %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	go func() {
+		if err := http.Serve(listener, mux); err != nil {
+			slog.Error("Metrics server stopped: " + err.Error())
+		}
+	}()
+
+	slog.Info(fmt.Sprintf("Serving metrics at http://%s/metrics", listener.Addr()))
+	return nil
+}
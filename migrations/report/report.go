@@ -0,0 +1,321 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+var FAILED_ERR error = errors.New("The run did not complete")
+
+// Reasons used as keys in `Series.RowsSkipped`
+const (
+	BEFORE_FROMTIME  = "before fromtime"
+	AFTER_TOTIME     = "after totime"
+	PAST_IMPORT_YEAR = "past import year"
+	ALREADY_IMPORTED = "already imported" // Committed by a previous run, see `checkpoint.Manifest.Progress`
+)
+
+// Structured summary of a dump or import run, shared by the KDVH and Kvalobs subcommands.
+// For dumps, `RowsInserted` is the number of rows written to the dump files.
+// For imports, rows that were already in LARD are counted either as `RowsUpdated`
+// or as `RowsConflicting`, depending on the conflict policy (see `lard.ConflictPolicy`).
+type Run struct {
+	mutex     sync.Mutex
+	Source    string    `json:"source"`    // "kdvh" or "kvalobs"
+	Procedure string    `json:"procedure"` // "dump" or "import"
+	Args      any       `json:"args"`      // Command line arguments used for the run
+	Start     time.Time `json:"start"`
+	End       time.Time `json:"end"`
+	Duration  Duration  `json:"duration"`
+	Totals    Totals    `json:"totals"`
+	Tables    []*Table  `json:"tables"`
+}
+
+type Table struct {
+	mutex    sync.Mutex
+	Name     string    `json:"name"`
+	Start    time.Time `json:"start"`
+	End      time.Time `json:"end"`
+	Duration Duration  `json:"duration"`
+	Totals   Totals    `json:"totals"`
+	Workers  []*Worker `json:"workers,omitempty"`
+	Series   []*Series `json:"series"`
+	Error    string    `json:"error,omitempty"` // Error that stopped the whole table
+}
+
+// Throughput of a single worker of a table. Each worker should only be updated by one goroutine
+type Worker struct {
+	ID            int      `json:"id"`
+	Series        int64    `json:"series"`
+	RowsInserted  int64    `json:"rows_inserted"`
+	Busy          Duration `json:"busy"` // Time spent processing series
+	RowsPerSecond float64  `json:"rows_per_second"`
+}
+
+type Series struct {
+	Station         int32            `json:"station"`
+	Series          string           `json:"series"` // Element code for KDVH, label for Kvalobs
+	RowsRead        int64            `json:"rows_read"`
+	RowsConverted   int64            `json:"rows_converted"`
+	RowsInserted    int64            `json:"rows_inserted"`
+	RowsUpdated     int64            `json:"rows_updated"`
+	RowsConflicting int64            `json:"rows_conflicting"` // Already in LARD and left unchanged
+	RowsSkipped     map[string]int64 `json:"rows_skipped,omitempty"`
+	Flags           FlagRows         `json:"flags"`             // Rows of `flags.kvdata`, the other counts are observations
+	Batches         []Batch          `json:"batches,omitempty"` // Batches committed to LARD, if the series was imported in batches
+	Skipped         string           `json:"skipped,omitempty"` // Reason why the whole series was skipped
+	Error           string           `json:"error,omitempty"`
+	Duration        Duration         `json:"duration"`
+	start           time.Time
+}
+
+// Rows of a series committed to LARD in a single transaction
+type Batch struct {
+	Number          int       `json:"number"`
+	Rows            int64     `json:"rows"` // Inserted rows
+	RowsUpdated     int64     `json:"rows_updated"`
+	RowsConflicting int64     `json:"rows_conflicting"`
+	Flags           FlagRows  `json:"flags"`
+	From            time.Time `json:"from"` // Obstime of the first row
+	To              time.Time `json:"to"`   // Obstime of the last row
+}
+
+// Aggregated counts over multiple series
+type Totals struct {
+	Series          int64            `json:"series"`
+	Failed          int64            `json:"failed"`
+	Skipped         int64            `json:"skipped"`
+	RowsRead        int64            `json:"rows_read"`
+	RowsConverted   int64            `json:"rows_converted"`
+	RowsInserted    int64            `json:"rows_inserted"`
+	RowsUpdated     int64            `json:"rows_updated"`
+	RowsConflicting int64            `json:"rows_conflicting"`
+	RowsSkipped     map[string]int64 `json:"rows_skipped,omitempty"`
+	Flags           FlagRows         `json:"flags"`
+}
+
+// Rows of `flags.kvdata` affected by an import
+type FlagRows struct {
+	Inserted    int64 `json:"inserted"`
+	Updated     int64 `json:"updated"`
+	Conflicting int64 `json:"conflicting"`
+}
+
+func (f *FlagRows) Add(other FlagRows) {
+	f.Inserted += other.Inserted
+	f.Updated += other.Updated
+	f.Conflicting += other.Conflicting
+}
+
+// Duration marshalled as (fractional) seconds
+type Duration time.Duration
+
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return []byte(fmt.Sprintf("%.3f", time.Duration(d).Seconds())), nil
+}
+
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var seconds float64
+	if err := json.Unmarshal(b, &seconds); err != nil {
+		return err
+	}
+	*d = Duration(seconds * float64(time.Second))
+	return nil
+}
+
+func New(source, procedure string, args any) *Run {
+	return &Run{Source: source, Procedure: procedure, Args: args, Start: time.Now().UTC()}
+}
+
+// Adds a new table to the run
+func (r *Run) NewTable(name string) *Table {
+	table := &Table{Name: name, Start: time.Now().UTC()}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.Tables = append(r.Tables, table)
+	return table
+}
+
+// Adds a new series to the table and starts its timer
+func (t *Table) NewSeries(station int32, series string) *Series {
+	s := &Series{Station: station, Series: series, start: time.Now()}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.Series = append(t.Series, s)
+	return s
+}
+
+// Records the error that stopped the whole table
+func (t *Table) Fail(err error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.Error = err.Error()
+}
+
+// Adds a new worker to the table
+func (t *Table) NewWorker(id int) *Worker {
+	w := &Worker{ID: id}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.Workers = append(t.Workers, w)
+	return w
+}
+
+// Records a series processed by the worker
+func (w *Worker) Record(rows int64, elapsed time.Duration) {
+	w.Series += 1
+	w.RowsInserted += rows
+	w.Busy += Duration(elapsed)
+}
+
+// Increases the number of rows that were skipped for the given reason
+func (s *Series) Skip(reason string) {
+	if s.RowsSkipped == nil {
+		s.RowsSkipped = make(map[string]int64)
+	}
+	s.RowsSkipped[reason] += 1
+}
+
+// Records a batch of the series committed to LARD
+func (s *Series) AddBatch(batch Batch) {
+	s.Batches = append(s.Batches, batch)
+}
+
+// Stops the series timer and records the error, if any
+func (s *Series) Finish(err error) {
+	s.Duration = Duration(time.Since(s.start))
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// Marks the whole series as skipped
+func (s *Series) SkipSeries(reason string) {
+	s.Duration = Duration(time.Since(s.start))
+	s.Skipped = reason
+}
+
+func (t *Totals) add(s *Series) {
+	t.Series += 1
+	if s.Error != "" {
+		t.Failed += 1
+	}
+	if s.Skipped != "" {
+		t.Skipped += 1
+	}
+	t.RowsRead += s.RowsRead
+	t.RowsConverted += s.RowsConverted
+	t.RowsInserted += s.RowsInserted
+	t.RowsUpdated += s.RowsUpdated
+	t.RowsConflicting += s.RowsConflicting
+	t.Flags.Add(s.Flags)
+	for reason, count := range s.RowsSkipped {
+		if t.RowsSkipped == nil {
+			t.RowsSkipped = make(map[string]int64)
+		}
+		t.RowsSkipped[reason] += count
+	}
+}
+
+func (t *Totals) merge(other Totals) {
+	t.Series += other.Series
+	t.Failed += other.Failed
+	t.Skipped += other.Skipped
+	t.RowsRead += other.RowsRead
+	t.RowsConverted += other.RowsConverted
+	t.RowsInserted += other.RowsInserted
+	t.RowsUpdated += other.RowsUpdated
+	t.RowsConflicting += other.RowsConflicting
+	t.Flags.Add(other.Flags)
+	for reason, count := range other.RowsSkipped {
+		if t.RowsSkipped == nil {
+			t.RowsSkipped = make(map[string]int64)
+		}
+		t.RowsSkipped[reason] += count
+	}
+}
+
+// Stops the table timer and computes the totals
+func (t *Table) Finish() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.End.IsZero() {
+		t.End = time.Now().UTC()
+		t.Duration = Duration(t.End.Sub(t.Start))
+	}
+	t.Totals = Totals{}
+	for _, s := range t.Series {
+		t.Totals.add(s)
+	}
+
+	for _, w := range t.Workers {
+		if seconds := time.Duration(w.Busy).Seconds(); seconds > 0 {
+			w.RowsPerSecond = float64(w.RowsInserted) / seconds
+		}
+	}
+}
+
+// Computes the run totals and writes the report as JSON
+func (r *Run) Write(filename string) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	r.End = time.Now().UTC()
+	r.Duration = Duration(r.End.Sub(r.Start))
+	r.Totals = Totals{}
+	for _, t := range r.Tables {
+		t.Finish()
+		r.Totals.merge(t.Totals)
+	}
+
+	file, err := os.Create(filename)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(file)
+	encoder.SetIndent("", "  ")
+	err = encoder.Encode(r)
+	if closeErr := file.Close(); closeErr != nil {
+		return closeErr
+	}
+	return err
+}
+
+// Returns an error if any table or series of the run failed, so the caller can stop
+func (r *Run) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	var tables, series int
+	for _, t := range r.Tables {
+		t.mutex.Lock()
+		if t.Error != "" {
+			tables += 1
+		}
+		for _, s := range t.Series {
+			if s.Error != "" {
+				series += 1
+			}
+		}
+		t.mutex.Unlock()
+	}
+
+	if tables == 0 && series == 0 {
+		return nil
+	}
+	return fmt.Errorf("%w: %d tables and %d series failed", FAILED_ERR, tables, series)
+}
+
+// Returns the default name of the report file, similar to the log files
+func (r *Run) Filename() string {
+	return fmt.Sprintf("%s_%s_report_%s.json", r.Source, r.Procedure, r.Start.Format(time.RFC3339))
+}
@@ -0,0 +1,78 @@
+package report
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWrite(t *testing.T) {
+	run := New("kdvh", "import", nil)
+
+	table := run.NewTable("T_ADATA")
+	ok := table.NewSeries(18700, "TA")
+	ok.RowsRead = 3
+	ok.RowsConverted = 2
+	ok.RowsInserted = 2
+	ok.RowsUpdated = 1
+	ok.RowsConflicting = 3
+	ok.Flags = FlagRows{Inserted: 2, Updated: 1, Conflicting: 1}
+	ok.AddBatch(Batch{Number: 1, Rows: 2, RowsUpdated: 1, RowsConflicting: 3, Flags: ok.Flags})
+	ok.Skip(BEFORE_FROMTIME)
+	ok.Finish(nil)
+
+	failed := table.NewSeries(18700, "FF")
+	failed.RowsRead = 1
+	failed.Skip(PAST_IMPORT_YEAR)
+	failed.Finish(errors.New("bad"))
+
+	table.NewSeries(18701, "TA").SkipSeries("restricted")
+
+	worker := table.NewWorker(0)
+	worker.Record(2, time.Second)
+	worker.Record(0, time.Second)
+
+	filename := filepath.Join(t.TempDir(), run.Filename())
+	if err := run.Write(filename); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got Run
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+
+	totals := got.Totals
+	if totals.Series != 3 || totals.Failed != 1 || totals.Skipped != 1 {
+		t.Errorf("Unexpected series totals: %+v", totals)
+	}
+	if totals.RowsRead != 4 || totals.RowsConverted != 2 || totals.RowsInserted != 2 {
+		t.Errorf("Unexpected row totals: %+v", totals)
+	}
+	if totals.RowsUpdated != 1 || totals.RowsConflicting != 3 {
+		t.Errorf("Unexpected upsert totals: %+v", totals)
+	}
+	if totals.Flags != (FlagRows{Inserted: 2, Updated: 1, Conflicting: 1}) {
+		t.Errorf("Unexpected flag totals: %+v", totals.Flags)
+	}
+	if totals.RowsSkipped[BEFORE_FROMTIME] != 1 || totals.RowsSkipped[PAST_IMPORT_YEAR] != 1 {
+		t.Errorf("Unexpected skipped rows: %v", totals.RowsSkipped)
+	}
+	if len(got.Tables) != 1 || got.Tables[0].Totals.Series != 3 {
+		t.Fatalf("Unexpected tables: %+v", got.Tables)
+	}
+	if batches := got.Tables[0].Series[0].Batches; len(batches) != 1 || batches[0].RowsUpdated != 1 || batches[0].RowsConflicting != 3 {
+		t.Errorf("Unexpected batches: %+v", batches)
+	}
+	if workers := got.Tables[0].Workers; len(workers) != 1 || workers[0].Series != 2 || workers[0].RowsPerSecond != 1 {
+		t.Errorf("Unexpected workers: %+v", workers)
+	}
+}
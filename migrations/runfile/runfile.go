@@ -0,0 +1,368 @@
+package runfile
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/alexflint/go-arg"
+	"github.com/joho/godotenv"
+
+	kdvh "migrate/kdvh/db"
+	kdvhdump "migrate/kdvh/dump"
+	kdvhport "migrate/kdvh/import"
+	kvalobs "migrate/kvalobs/db"
+	kvalobsdump "migrate/kvalobs/dump"
+	kvalobsport "migrate/kvalobs/import"
+	"migrate/lard"
+	"migrate/metrics"
+	"migrate/stinfosys"
+)
+
+// A run file is a TOML document describing a sequence of dump and import steps, e.g.
+//
+//	# Optional list of env files loaded before the steps run
+//	env = [".env"]
+//	# Optional address the Prometheus metrics of all the steps are served at
+//	metrics-addr = ":9090"
+//
+//	[[step]]
+//	name = "Dump T_ADATA"
+//	source = "kdvh"
+//	procedure = "dump"
+//	tables = ["T_ADATA"]
+//	stations = ["18700"]
+//	n = 8
+//
+//	[[step]]
+//	source = "kdvh"
+//	procedure = "import"
+//	tables = ["T_ADATA"]
+//	sep = ";"
+//	reindex = true
+//
+// Apart from `name`, `source` and `procedure`, the keys of each step are the
+// command line flags of the corresponding subcommand (e.g. `migrate kdvh import --help`)
+// without the leading dashes. Arrays are passed as space separated lists, and boolean
+// flags are only set if `true`.
+
+// Command line arguments for `migrate run`
+type Config struct {
+	File string `arg:"positional,required" help:"Path to the TOML run file"`
+}
+
+type Plan struct {
+	Env         []string `toml:"env"`          // Env files loaded before running the steps
+	MetricsAddr string   `toml:"metrics-addr"` // Served once for the whole run
+	Steps       []*Step  `toml:"-"`
+}
+
+// Layout of the run file, the options of each step are decoded separately
+type document struct {
+	Plan
+	Step []toml.Primitive `toml:"step"`
+}
+
+type Step struct {
+	Name      string   `toml:"name"`
+	Source    string   `toml:"source"`    // "kdvh" or "kvalobs"
+	Procedure string   `toml:"procedure"` // "dump" or "import"
+	Args      []string `toml:"-"`         // Command line arguments of the step
+	EnvVars   []string `toml:"-"`         // Env variables needed by the step
+	execute   func(ctx context.Context) error
+}
+
+// Keys of a step that are not passed to the subcommand
+var STEP_KEYS = []string{"name", "source", "procedure"}
+
+func (s *Step) String() string {
+	name := fmt.Sprintf("%s %s %s", s.Source, s.Procedure, strings.Join(s.Args, " "))
+	if s.Name != "" {
+		name = s.Name + " (" + name + ")"
+	}
+	return name
+}
+
+// Reads and validates the run file, without running any step
+func Load(filename string) (*Plan, error) {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	plan, err := Parse(string(content))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	return plan, nil
+}
+
+// Parses the run file and validates the arguments of all the steps
+func Parse(content string) (*Plan, error) {
+	var doc document
+	meta, err := toml.Decode(content, &doc)
+	if err != nil {
+		return nil, err
+	}
+
+	// The keys of the steps are only decoded later, see `newStep`
+	for _, key := range meta.Undecoded() {
+		if len(key) == 1 {
+			return nil, fmt.Errorf("unknown key %q. Choices: ['env', 'metrics-addr', 'step']", key.String())
+		}
+	}
+
+	if len(doc.Step) == 0 {
+		return nil, errors.New("no steps defined, use '[[step]]' to add one")
+	}
+
+	// Collect all the errors, so they can be fixed in one go
+	var errs []error
+	plan := doc.Plan
+	for i, primitive := range doc.Step {
+		step, err := newStep(meta, primitive)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("step %d: %w", i+1, err))
+			continue
+		}
+		plan.Steps = append(plan.Steps, step)
+	}
+
+	if err := errors.Join(errs...); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func newStep(meta toml.MetaData, primitive toml.Primitive) (*Step, error) {
+	var step Step
+	if err := meta.PrimitiveDecode(primitive, &step); err != nil {
+		return nil, err
+	}
+
+	options := make(map[string]any)
+	if err := meta.PrimitiveDecode(primitive, &options); err != nil {
+		return nil, err
+	}
+	for _, key := range STEP_KEYS {
+		delete(options, key)
+	}
+
+	// Each step would otherwise try to listen on the same address
+	if _, ok := options["metrics-addr"]; ok {
+		return nil, errors.New("'metrics-addr' can only be set once, at the top of the run file")
+	}
+
+	var err error
+	if step.Args, err = toArgs(options); err != nil {
+		return nil, err
+	}
+
+	var dest any
+	switch step.Source + " " + step.Procedure {
+	case "kdvh dump":
+		config := &kdvhdump.Config{}
+		dest = config
+		step.execute = config.Execute
+	case "kdvh import":
+		config := &kdvhport.Config{}
+		dest = config
+		step.execute = config.Execute
+	case "kvalobs dump":
+		config := &kvalobsdump.Config{}
+		dest = config
+		step.execute = config.Execute
+	case "kvalobs import":
+		config := &kvalobsport.Config{}
+		dest = config
+		step.execute = config.Execute
+	default:
+		return nil, fmt.Errorf(
+			"invalid source and procedure %q. Choices: ['kdvh dump', 'kdvh import', 'kvalobs dump', 'kvalobs import']",
+			step.Source+" "+step.Procedure,
+		)
+	}
+
+	parser, err := arg.NewParser(arg.Config{Program: "migrate " + step.Source + " " + step.Procedure}, dest)
+	if err != nil {
+		return nil, err
+	}
+	if err := parser.Parse(step.Args); err != nil {
+		return nil, err
+	}
+
+	switch config := dest.(type) {
+	case *kdvhdump.Config:
+		step.EnvVars = kdvh.Init().EnvVars(config.Tables)
+		err = config.Validate()
+	case *kdvhport.Config:
+		step.EnvVars = []string{lard.LARD_ENV_VAR, stinfosys.STINFOSYS_ENV_VAR}
+		if !config.OfflineMetadata {
+			step.EnvVars = append(step.EnvVars, kdvh.Init().EnvVars(config.Tables)...)
+		}
+		err = config.Validate()
+	case *kvalobsdump.Config:
+		step.EnvVars, err = kvalobsEnvVars(config.Database, config.Table)
+	case *kvalobsport.Config:
+		// Metadata is always cached from histkvalobs
+		step.EnvVars = []string{lard.LARD_ENV_VAR, stinfosys.STINFOSYS_ENV_VAR, kvalobs.InitDBs()["histkvalobs"].ConnEnvVar}
+		_, err = kvalobsEnvVars(config.Database, config.Table)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+	return &step, nil
+}
+
+// Checks the Kvalobs database and table names, and returns the env variables of the selected databases
+func kvalobsEnvVars(database, table string) (vars []string, err error) {
+	dbs := kvalobs.InitDBs()
+
+	names := make([]string, 0, len(dbs))
+	for name := range dbs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	if database != "" && !slices.Contains(names, database) {
+		return nil, fmt.Errorf("invalid database %q. Choices: %v", database, names)
+	}
+
+	for _, name := range names {
+		if database != "" && name != database {
+			continue
+		}
+
+		db := dbs[name]
+		if _, ok := db.Tables[table]; table != "" && !ok {
+			return nil, fmt.Errorf("invalid table %q for database %q", table, name)
+		}
+		vars = append(vars, db.ConnEnvVar)
+	}
+	return vars, nil
+}
+
+// Converts the step options to command line flags, sorted by key
+func toArgs(options map[string]any) ([]string, error) {
+	keys := make([]string, 0, len(options))
+	for key := range options {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	var args []string
+	for _, key := range keys {
+		flag := "--" + key
+		if len(key) == 1 {
+			flag = "-" + key
+		}
+
+		switch value := options[key].(type) {
+		case bool:
+			if value {
+				args = append(args, flag)
+			}
+		case map[string]any:
+			return nil, fmt.Errorf("%s: nested tables are not supported", key)
+		case []any:
+			if len(value) == 0 {
+				continue
+			}
+			values, err := toStrings(value)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", key, err)
+			}
+			args = append(args, flag)
+			args = append(args, values...)
+		default:
+			args = append(args, flag, fmt.Sprint(value))
+		}
+	}
+	return args, nil
+}
+
+// Accepts a single value or an array of scalar values
+func toStrings(value any) ([]string, error) {
+	array, ok := value.([]any)
+	if !ok {
+		array = []any{value}
+	}
+
+	out := make([]string, len(array))
+	for i, v := range array {
+		switch v.(type) {
+		case []any, map[string]any:
+			return nil, fmt.Errorf("nested values are not supported")
+		}
+		out[i] = fmt.Sprint(v)
+	}
+	return out, nil
+}
+
+// Returns an error listing the env variables needed by the steps that are not set
+func (p *Plan) CheckEnv() error {
+	var missing []string
+	for _, step := range p.Steps {
+		for _, name := range step.EnvVars {
+			if os.Getenv(name) == "" && !slices.Contains(missing, name) {
+				missing = append(missing, name)
+			}
+		}
+	}
+
+	if len(missing) > 0 {
+		return fmt.Errorf("missing env variables: %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+func (config *Config) Execute(ctx context.Context) {
+	plan, err := Load(config.File)
+	if err != nil {
+		fmt.Println("Invalid run file:\n" + err.Error())
+		return
+	}
+
+	if len(plan.Env) > 0 {
+		if err := godotenv.Overload(plan.Env...); err != nil {
+			fmt.Println(err)
+			return
+		}
+	}
+
+	if err := plan.CheckEnv(); err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	if plan.MetricsAddr != "" {
+		if err := metrics.Serve(plan.MetricsAddr); err != nil {
+			slog.Error(err.Error())
+			fmt.Println(err)
+			return
+		}
+	}
+
+	for i, step := range plan.Steps {
+		if ctx.Err() != nil {
+			fmt.Println("Run interrupted")
+			return
+		}
+
+		header := fmt.Sprintf("Step %d/%d: %s", i+1, len(plan.Steps), step)
+		fmt.Println(header)
+		slog.Info(header)
+
+		if err := step.execute(ctx); err != nil {
+			fmt.Printf("Step %d failed, stopping: %s\n", i+1, err)
+			slog.Error(fmt.Sprintf("Step %d failed: %s", i+1, err))
+			return
+		}
+	}
+}
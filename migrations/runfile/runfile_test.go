@@ -0,0 +1,150 @@
+package runfile
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestParseTOML(t *testing.T) {
+	plan, err := Parse(`
+# Comment
+env = [".env"] # Trailing comment
+metrics-addr = ":9090"
+
+[[step]]
+name = "with # inside"
+source = "kdvh"
+procedure = "import"
+tables = [
+    "T_ADATA", # First table
+    'T_MDATA',
+]
+workers = 1_000
+reindex = true
+
+[[step]]
+source = "kdvh"
+procedure = "dump"
+stations = [18700, 18701]
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !slices.Equal(plan.Env, []string{".env"}) || plan.MetricsAddr != ":9090" {
+		t.Errorf("Unexpected plan: %+v", plan)
+	}
+	if len(plan.Steps) != 2 {
+		t.Fatalf("Expected 2 steps, got %d", len(plan.Steps))
+	}
+
+	first := plan.Steps[0]
+	expected := []string{"--reindex", "--tables", "T_ADATA", "T_MDATA", "--workers", "1000"}
+	if first.Name != "with # inside" || !slices.Equal(first.Args, expected) {
+		t.Errorf("Unexpected first step: %+v", first)
+	}
+	if args := plan.Steps[1].Args; !slices.Equal(args, []string{"--stations", "18700", "18701"}) {
+		t.Errorf("Unexpected stations: %v", args)
+	}
+}
+
+func TestParse(t *testing.T) {
+	plan, err := Parse(`
+[[step]]
+source = "kdvh"
+procedure = "import"
+tables = ["T_ADATA"]
+sep = ";"
+workers = 8
+reindex = true
+resume = false
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	step := plan.Steps[0]
+	expected := []string{"--reindex", "--sep", ";", "--tables", "T_ADATA", "--workers", "8"}
+	if !slices.Equal(step.Args, expected) {
+		t.Errorf("Got args %v, wanted %v", step.Args, expected)
+	}
+	if !slices.Contains(step.EnvVars, "LARD_CONN_STRING") {
+		t.Errorf("Missing LARD env variable: %v", step.EnvVars)
+	}
+}
+
+func TestKDVHSources(t *testing.T) {
+	plan, err := Parse(`
+[[step]]
+source = "kdvh"
+procedure = "dump"
+tables = ["T_ADATA", "T_AVINOR"]
+
+[[step]]
+source = "kdvh"
+procedure = "import"
+offline-metadata = true
+`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{"KDVH_AVINOR_CONN_STRING", "KDVH_PROXY_CONN_STRING"}
+	if !slices.Equal(plan.Steps[0].EnvVars, expected) {
+		t.Errorf("Got env variables %v, wanted %v", plan.Steps[0].EnvVars, expected)
+	}
+	if slices.Contains(plan.Steps[1].EnvVars, "KDVH_PROXY_CONN_STRING") {
+		t.Errorf("Offline import should not need the KDVH proxy: %v", plan.Steps[1].EnvVars)
+	}
+}
+
+func TestParseInvalid(t *testing.T) {
+	_, err := Parse(`
+[[step]]
+source = "kdvh"
+procedure = "migrate"
+
+[[step]]
+source = "kvalobs"
+procedure = "dump"
+db = "unknown"
+
+[[step]]
+source = "kdvh"
+procedure = "import"
+sep = ";;"
+`)
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	// All the invalid steps should be reported
+	for _, step := range []string{"step 1", "step 2", "step 3"} {
+		if !strings.Contains(err.Error(), step) {
+			t.Errorf("Missing %q in error: %s", step, err)
+		}
+	}
+}
+
+func TestParseMetricsAddr(t *testing.T) {
+	type testCase struct {
+		content  string
+		expected string
+	}
+
+	cases := []testCase{
+		{"unknown = 1\n[[step]]\nsource = \"kdvh\"\nprocedure = \"dump\"", `unknown key "unknown"`},
+		{"[[step]]\nsource = \"kdvh\"\nprocedure = \"dump\"\nmetrics-addr = \":9090\"", "can only be set once"},
+		{"[[step]]\nsource = \"kdvh\"\nprocedure = \"dump\"\nincremental = true\noverwrite = true", "cannot be used together"},
+	}
+
+	for _, c := range cases {
+		t.Log("Testing", c.expected)
+
+		_, err := Parse(c.content)
+		if err == nil || !strings.Contains(err.Error(), c.expected) {
+			t.Errorf("Expected error containing %q, got %v", c.expected, err)
+		}
+	}
+}
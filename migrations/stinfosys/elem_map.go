@@ -2,11 +2,11 @@ package stinfosys
 
 import (
 	"context"
-	"log/slog"
-	"os"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+
+	"migrate/utils"
 )
 
 // Map of metadata used to query timeseries ID in LARD
@@ -29,19 +29,19 @@ type Param struct {
 }
 
 // Save metadata for later use by quering Stinfosys
-func CacheElemMap(conn *pgx.Conn) ElemMap {
+func CacheElemMap(ctx context.Context, conn *pgx.Conn) (ElemMap, error) {
 	cache := make(ElemMap)
 
 	rows, err := conn.Query(
-		context.TODO(),
+		ctx,
 		`SELECT elem_code, table_name, typeid, paramid, hlevel, sensor, fromtime, scalar
             FROM elem_map_cfnames_param
             JOIN param USING(paramid)`,
 	)
 	if err != nil {
-		slog.Error(err.Error())
-		os.Exit(1)
+		return nil, utils.NewSourceError(utils.STINFOSYS, err)
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var key Key
@@ -57,17 +57,15 @@ func CacheElemMap(conn *pgx.Conn) ElemMap {
 			&param.IsScalar,
 		)
 		if err != nil {
-			slog.Error(err.Error())
-			os.Exit(1)
+			return nil, utils.NewSourceError(utils.STINFOSYS, err)
 		}
 
 		cache[key] = param
 	}
 
 	if rows.Err() != nil {
-		slog.Error(rows.Err().Error())
-		os.Exit(1)
+		return nil, utils.NewSourceError(utils.STINFOSYS, rows.Err())
 	}
 
-	return cache
+	return cache, nil
 }
@@ -2,22 +2,25 @@ package stinfosys
 
 import (
 	"context"
-	"log"
+	"fmt"
 	"os"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+
+	"migrate/utils"
 )
 
 const STINFOSYS_ENV_VAR string = "STINFO_CONN_STRING"
 
-func Connect() (*pgx.Conn, context.Context) {
-	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+func Connect(ctx context.Context) (*pgx.Conn, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Second)
 	defer cancel()
 
 	conn, err := pgx.Connect(ctx, os.Getenv(STINFOSYS_ENV_VAR))
 	if err != nil {
-		log.Fatal("Could not connect to Stinfosys. Make sure to be connected to the VPN. " + err.Error())
+		err = fmt.Errorf("could not connect, make sure to be connected to the VPN - %w", err)
+		return nil, utils.NewSourceError(utils.STINFOSYS, err)
 	}
-	return conn, ctx
+	return conn, nil
 }
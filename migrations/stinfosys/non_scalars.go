@@ -2,19 +2,20 @@ package stinfosys
 
 import (
 	"context"
-	"log"
 
 	"github.com/jackc/pgx/v5"
+
+	"migrate/utils"
 )
 
-func GetNonScalars(conn *pgx.Conn) []int32 {
-	rows, err := conn.Query(context.TODO(), "SELECT paramid FROM param WHERE scalar = false ORDER BY paramid")
+func GetNonScalars(ctx context.Context, conn *pgx.Conn) ([]int32, error) {
+	rows, err := conn.Query(ctx, "SELECT paramid FROM param WHERE scalar = false ORDER BY paramid")
 	if err != nil {
-		log.Fatal(err)
+		return nil, utils.NewSourceError(utils.STINFOSYS, err)
 	}
 	nonscalars, err := pgx.CollectRows(rows, pgx.RowTo[int32])
 	if err != nil {
-		log.Fatal(err)
+		return nil, utils.NewSourceError(utils.STINFOSYS, err)
 	}
-	return nonscalars
+	return nonscalars, nil
 }
@@ -2,10 +2,10 @@ package stinfosys
 
 import (
 	"context"
-	"log/slog"
-	"os"
 
 	"github.com/jackc/pgx/v5"
+
+	"migrate/utils"
 )
 
 const STINFO_ENV_VAR string = "STINFO_CONN_STRING"
@@ -27,75 +27,78 @@ type PermitMaps struct {
 	StationPermits StationPermitMap
 }
 
-func NewPermitTables(conn *pgx.Conn) PermitMaps {
-	return PermitMaps{
-		ParamPermits:   cacheParamPermits(conn),
-		StationPermits: cacheStationPermits(conn),
+func NewPermitTables(ctx context.Context, conn *pgx.Conn) (PermitMaps, error) {
+	paramPermits, err := cacheParamPermits(ctx, conn)
+	if err != nil {
+		return PermitMaps{}, utils.NewSourceError(utils.STINFOSYS, err)
+	}
+
+	stationPermits, err := cacheStationPermits(ctx, conn)
+	if err != nil {
+		return PermitMaps{}, utils.NewSourceError(utils.STINFOSYS, err)
 	}
+
+	return PermitMaps{ParamPermits: paramPermits, StationPermits: stationPermits}, nil
 }
 
-func cacheParamPermits(conn *pgx.Conn) ParamPermitMap {
+func cacheParamPermits(ctx context.Context, conn *pgx.Conn) (ParamPermitMap, error) {
 	cache := make(ParamPermitMap)
 
 	rows, err := conn.Query(
-		context.TODO(),
+		ctx,
 		"SELECT stationid, message_formatid, paramid, permitid FROM v_station_param_policy",
 	)
 	if err != nil {
-		slog.Error(err.Error())
-		os.Exit(1)
+		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var stnr StationId
 		var permit ParamPermit
 
 		if err := rows.Scan(&stnr, &permit.TypeId, &permit.ParamdId, &permit.PermitId); err != nil {
-			slog.Error(err.Error())
-			os.Exit(1)
+			return nil, err
 		}
 
 		cache[stnr] = append(cache[stnr], permit)
 	}
 
 	if rows.Err() != nil {
-		slog.Error(rows.Err().Error())
-		os.Exit(1)
+		return nil, rows.Err()
 	}
 
-	return cache
+	return cache, nil
 }
 
-func cacheStationPermits(conn *pgx.Conn) StationPermitMap {
+func cacheStationPermits(ctx context.Context, conn *pgx.Conn) (StationPermitMap, error) {
 	cache := make(StationPermitMap)
 
 	rows, err := conn.Query(
-		context.TODO(),
+		ctx,
 		"SELECT stationid, permitid FROM station_policy",
 	)
 	if err != nil {
-		slog.Error(err.Error())
-		os.Exit(1)
+		return nil, err
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var stnr StationId
 		var permit PermitId
 
 		if err := rows.Scan(&stnr, &permit); err != nil {
-			slog.Error(err.Error())
-			os.Exit(1)
+			return nil, err
 		}
 
 		cache[stnr] = permit
 	}
 
 	if rows.Err() != nil {
-		slog.Error(rows.Err().Error())
-		os.Exit(1)
+		return nil, rows.Err()
 	}
 
-	return cache
+	return cache, nil
 }
 
 func (permits *PermitMaps) TimeseriesIsOpen(stnr, typeid, paramid int32) bool {
@@ -2,7 +2,6 @@ package stinfosys
 
 import (
 	"context"
-	"log"
 	kvalobs "migrate/kvalobs/db"
 	"migrate/utils"
 
@@ -11,15 +10,16 @@ import (
 
 type TimespanMap = map[kvalobs.Label]utils.TimeSpan
 
-func getTimeseries(conn *pgx.Conn) TimespanMap {
+func getTimeseries(ctx context.Context, conn *pgx.Conn) (TimespanMap, error) {
 	cache := make(TimespanMap)
 
-	rows, err := conn.Query(context.TODO(),
+	rows, err := conn.Query(ctx,
 		`SELECT stationid, message_formatid, paramid, sensor, level, fromtime, totime
             FROM time_series`)
 	if err != nil {
-		log.Fatal(err)
+		return nil, utils.NewSourceError(utils.STINFOSYS, err)
 	}
+	defer rows.Close()
 
 	for rows.Next() {
 		var label kvalobs.Label
@@ -35,11 +35,15 @@ func getTimeseries(conn *pgx.Conn) TimespanMap {
 			&timespan.To,
 		)
 		if err != nil {
-			log.Fatal(err)
+			return nil, utils.NewSourceError(utils.STINFOSYS, err)
 		}
 
 		cache[label] = timespan
 	}
 
-	return cache
+	if rows.Err() != nil {
+		return nil, utils.NewSourceError(utils.STINFOSYS, rows.Err())
+	}
+
+	return cache, nil
 }
@@ -12,6 +12,7 @@ import (
 	"migrate/kdvh/db"
 	port "migrate/kdvh/import"
 	"migrate/kdvh/import/cache"
+	"migrate/report"
 	"migrate/stinfosys"
 )
 
@@ -73,7 +74,7 @@ func TestImportKDVH(t *testing.T) {
 			t.Fatal("Table does not exist in database")
 		}
 
-		insertedRows := port.ImportTable(table, cache, pool, config)
+		insertedRows := port.ImportTable(context.Background(), table, cache, pool, config, report.New("kdvh", "import", config).NewTable(table.TableName))
 		if insertedRows != c.expectedRows {
 			t.Fail()
 		}
@@ -12,6 +12,7 @@ import (
 	kvalobs "migrate/kvalobs/db"
 	port "migrate/kvalobs/import"
 	"migrate/kvalobs/import/cache"
+	"migrate/report"
 	"migrate/stinfosys"
 	"migrate/utils"
 )
@@ -86,7 +87,7 @@ func TestImportDataKvalobs(t *testing.T) {
 		table := db.Tables[c.table]
 		table.Path = filepath.Join(DUMPS_PATH, db.Name, table.Name)
 
-		insertedRows, err := port.ImportTable(table, cache, pool, config)
+		insertedRows, err := port.ImportTable(context.Background(), table, cache, pool, config, report.New("kvalobs", "import", config).NewTable(table.Name))
 
 		switch {
 		case err != nil:
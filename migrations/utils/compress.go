@@ -0,0 +1,119 @@
+package utils
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Compression applied to the dumped CSV files
+type Compression string
+
+const (
+	NO_COMPRESSION Compression = ""
+	GZIP           Compression = "gzip"
+	ZSTD           Compression = "zstd"
+)
+
+func (c *Compression) UnmarshalText(b []byte) error {
+	switch compression := Compression(b); compression {
+	case GZIP, ZSTD:
+		*c = compression
+		return nil
+	}
+	return fmt.Errorf("Invalid compression %q. Choices: ['%s', '%s']", b, GZIP, ZSTD)
+}
+
+// Extension appended to the `.csv` extension of compressed files
+func (c Compression) Extension() string {
+	switch c {
+	case GZIP:
+		return ".gz"
+	case ZSTD:
+		return ".zst"
+	}
+	return ""
+}
+
+// Writer that closes both the compression stream and the underlying file
+type compressedFile struct {
+	io.WriteCloser
+	file *os.File
+}
+
+func (f *compressedFile) Close() error {
+	err := f.WriteCloser.Close()
+	if closeErr := f.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Creates the file, compressing everything written to it.
+// The extension of the compression is NOT appended to the filename.
+func CreateCompressed(filename string, compression Compression) (io.WriteCloser, error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var writer io.WriteCloser
+	switch compression {
+	case GZIP:
+		writer = gzip.NewWriter(file)
+	case ZSTD:
+		if writer, err = zstd.NewWriter(file); err != nil {
+			file.Close()
+			return nil, err
+		}
+	default:
+		return file, nil
+	}
+
+	return &compressedFile{writer, file}, nil
+}
+
+// Reader that closes both the decompression stream and the underlying file
+type decompressedFile struct {
+	io.Reader
+	close func()
+	file  *os.File
+}
+
+func (f *decompressedFile) Close() error {
+	if f.close != nil {
+		f.close()
+	}
+	return f.file.Close()
+}
+
+// Opens the file, decompressing it based on its extension (`.gz` or `.zst`)
+func OpenDecompressed(filename string) (io.ReadCloser, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case strings.HasSuffix(filename, GZIP.Extension()):
+		reader, err := gzip.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		return &decompressedFile{reader, func() { reader.Close() }, file}, nil
+	case strings.HasSuffix(filename, ZSTD.Extension()):
+		reader, err := zstd.NewReader(file)
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("%s: %w", filename, err)
+		}
+		return &decompressedFile{reader, reader.Close, file}, nil
+	}
+
+	return file, nil
+}
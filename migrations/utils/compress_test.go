@@ -0,0 +1,45 @@
+package utils
+
+import (
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+func TestCompressionRoundTrip(t *testing.T) {
+	content := "2001-07-01_09:00:00,12.9,70000\n# rows: 1\n"
+
+	for _, compression := range []Compression{NO_COMPRESSION, GZIP, ZSTD} {
+		t.Log("Testing compression:", compression)
+
+		filename := filepath.Join(t.TempDir(), "TA.csv"+compression.Extension())
+		writer, err := CreateCompressed(filename, compression)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.WriteString(writer, content); err != nil {
+			t.Fatal(err)
+		}
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		reader, err := OpenDecompressed(filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+		result, err := io.ReadAll(reader)
+		reader.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(result) != content {
+			t.Errorf("Got %q, wanted %q", result, content)
+		}
+
+		if name, ok := TrimDumpExtension(filepath.Base(filename)); !ok || name != "TA" {
+			t.Errorf("Got %q, wanted %q", name, "TA")
+		}
+	}
+}
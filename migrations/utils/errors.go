@@ -0,0 +1,32 @@
+package utils
+
+import "fmt"
+
+// Names of the sources of metadata needed for the imports
+const (
+	STINFOSYS     = "Stinfosys"
+	KDVH_PROXY    = "KDVH proxy"
+	KDVH_AVINOR   = "KDVH Avinor"
+	KDVH_PROJDATA = "KDVH Projdata"
+	KVALOBS       = "Kvalobs"
+	OFFSETS_CSV   = "offsets CSV"
+)
+
+// Error returned when metadata could not be fetched from one of the sources above.
+// Use `errors.As` to find out which source broke
+type SourceError struct {
+	Source string
+	Err    error
+}
+
+func NewSourceError(source string, err error) *SourceError {
+	return &SourceError{Source: source, Err: err}
+}
+
+func (e *SourceError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Source, e.Err)
+}
+
+func (e *SourceError) Unwrap() error {
+	return e.Err
+}
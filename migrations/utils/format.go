@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/parquet-go/parquet-go"
+)
+
+// File format of the dumped series
+type Format string
+
+const (
+	CSV     Format = "csv"
+	PARQUET Format = "parquet"
+)
+
+func (f *Format) UnmarshalText(b []byte) error {
+	switch format := Format(b); format {
+	case CSV, PARQUET:
+		*f = format
+		return nil
+	}
+	return fmt.Errorf("Invalid format %q. Choices: ['%s', '%s']", b, CSV, PARQUET)
+}
+
+// Extension of the dumped files. Parquet files are compressed internally,
+// so only CSV files get the extension of the compression
+func (f Format) Extension(compression Compression) string {
+	if f == PARQUET {
+		return ".parquet"
+	}
+	return ".csv" + compression.Extension()
+}
+
+// Extensions of the dumped files, sorted from the most to the least specific
+var dumpExtensions = []string{
+	CSV.Extension(GZIP),
+	CSV.Extension(ZSTD),
+	CSV.Extension(NO_COMPRESSION),
+	PARQUET.Extension(NO_COMPRESSION),
+}
+
+// Removes the extension of a dumped file, returning false if the file is not a dumped series
+func TrimDumpExtension(filename string) (string, bool) {
+	for _, ext := range dumpExtensions {
+		if name, found := strings.CutSuffix(filename, ext); found {
+			return name, true
+		}
+	}
+	return filename, false
+}
+
+func IsParquet(filename string) bool {
+	return strings.HasSuffix(filename, PARQUET.Extension(NO_COMPRESSION))
+}
+
+// Writer of Parquet files with rows of type T, see https://pkg.go.dev/github.com/parquet-go/parquet-go
+type ParquetWriter[T any] struct {
+	*parquet.GenericWriter[T]
+	file *os.File
+}
+
+// Creates the Parquet file, compressing the columns with the given compression
+func CreateParquet[T any](filename string, compression Compression) (*ParquetWriter[T], error) {
+	file, err := os.Create(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var options []parquet.WriterOption
+	switch compression {
+	case GZIP:
+		options = append(options, parquet.Compression(&parquet.Gzip))
+	case ZSTD:
+		options = append(options, parquet.Compression(&parquet.Zstd))
+	}
+
+	return &ParquetWriter[T]{parquet.NewGenericWriter[T](file, options...), file}, nil
+}
+
+// Flushes the remaining rows and the file footer, and closes the file
+func (w *ParquetWriter[T]) Close() error {
+	err := w.GenericWriter.Close()
+	if closeErr := w.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Returns the number of rows stored in the metadata of the Parquet file
+func CountParquetRows(filename string) (int64, error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil {
+		return 0, err
+	}
+
+	pf, err := parquet.OpenFile(file, info.Size())
+	if err != nil {
+		return 0, fmt.Errorf("%s: %w", filename, err)
+	}
+	return pf.NumRows(), nil
+}
+
+// Reader of Parquet files with rows of type T
+type ParquetReader[T any] struct {
+	*parquet.GenericReader[T]
+	file *os.File
+}
+
+// Opens the Parquet file, checking that its schema is compatible with T
+func OpenParquet[T any](filename string) (reader *ParquetReader[T], err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	pf, err := parquet.OpenFile(file, info.Size())
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	// The reader panics if the schemas are not compatible
+	defer func() {
+		if r := recover(); r != nil {
+			file.Close()
+			reader, err = nil, fmt.Errorf("%s: %v", filename, r)
+		}
+	}()
+
+	return &ParquetReader[T]{parquet.NewGenericReader[T](pf), file}, nil
+}
+
+// Calls fn for each row of the file, stopping at the first error
+func (r *ParquetReader[T]) Each(fn func(*T) error) error {
+	for {
+		// Allocate a new batch, so fn can keep references to the rows
+		rows := make([]T, 1024)
+		n, err := r.Read(rows)
+		for i := range n {
+			if err := fn(&rows[i]); err != nil {
+				return err
+			}
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *ParquetReader[T]) Close() error {
+	err := r.GenericReader.Close()
+	if closeErr := r.file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
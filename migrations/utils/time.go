@@ -18,6 +18,10 @@ func (ts *Timestamp) UnmarshalText(b []byte) error {
 	return nil
 }
 
+func (ts Timestamp) MarshalText() ([]byte, error) {
+	return []byte(ts.t.Format(time.DateOnly)), nil
+}
+
 // func (ts *Timestamp) Format(layout string) string {
 // 	return ts.t.Format(layout)
 // }